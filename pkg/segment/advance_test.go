@@ -0,0 +1,178 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestSetOnAdvanceOncePerAdvance(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		offsets []int64
+	}{
+		{name: "one offset", offsets: []int64{1}},
+		{name: "many offsets", offsets: []int64{1, 2, 3}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var lcd int64
+			for _, offset := range tc.offsets {
+				lcd += offset
+			}
+			index := NewSegmentedIndex(0, lcd, tc.offsets)
+			var mu sync.Mutex
+			seen := make(map[int64]int)
+			SetOnAdvance(index, func(result SegmentedIndexResult) {
+				index.Current() // it's called with the index unlocked
+				mu.Lock()
+				seen[result.Scaled]++
+				mu.Unlock()
+			})
+
+			const goroutines, calls = 8, 500
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < calls; i++ {
+						index.Next()
+					}
+				}()
+			}
+			wg.Wait()
+			if len(seen) != goroutines*calls {
+				t.Fatalf("expected %d different results but got %d", goroutines*calls, len(seen))
+			}
+			for scaled, n := range seen {
+				if n != 1 {
+					t.Fatalf("the result for %d was seen %d times", scaled, n)
+				}
+			}
+		})
+	}
+}
+
+func TestSetOnAdvanceCalls(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name  string
+		move  func(*SegmentedIndex)
+		calls int
+	}{
+		{name: "next", move: func(s *SegmentedIndex) { s.Next() }, calls: 1},
+		{name: "prev", move: func(s *SegmentedIndex) { _, _ = s.Prev() }, calls: 1},
+		{name: "goTo", move: func(s *SegmentedIndex) { s.GoTo(10) }, calls: 1},
+		{name: "next at the bound", move: func(s *SegmentedIndex) { s.SetMax(2); s.Next() }, calls: 0},
+		{name: "frozen", move: func(s *SegmentedIndex) { s.Freeze(); s.Next(); s.GoTo(10); _, _ = s.Prev() }, calls: 0},
+		{name: "nextN", move: func(s *SegmentedIndex) { _, _ = s.NextN(3) }, calls: 3},
+		{name: "tryNext", move: func(s *SegmentedIndex) { s.TryNext() }, calls: 1},
+		{name: "compareAndNext", move: func(s *SegmentedIndex) { s.CompareAndNext(2) }, calls: 1},
+		{name: "compareAndNext failing", move: func(s *SegmentedIndex) { s.CompareAndNext(1) }, calls: 0},
+		{name: "claim", move: func(s *SegmentedIndex) { s.Claim() }, calls: 1},
+		{
+			name:  "claimOrWait",
+			move:  func(s *SegmentedIndex) { _, _ = s.ClaimOrWait(context.Background(), time.Second) },
+			calls: 1,
+		},
+		{
+			name: "forEach",
+			move: func(s *SegmentedIndex) {
+				_ = s.ForEach(10, func(int64, int64) (goja.Value, error) { return nil, nil })
+			},
+			calls: 5,
+		},
+		{
+			name: "nextIntoArray",
+			move: func(s *SegmentedIndex) {
+				rt := goja.New()
+				buf, _ := rt.RunString(`new Float64Array(2)`)
+				_, _ = s.NextIntoArray(buf.ToObject(rt))
+			},
+			calls: 2,
+		},
+		{name: "advance", move: func(s *SegmentedIndex) { _, _ = s.Advance(3) }, calls: 1},
+		{name: "advance to the bound", move: func(s *SegmentedIndex) { s.SetMax(5); _, _ = s.Advance(10) }, calls: 1},
+		{name: "rewind past the start", move: func(s *SegmentedIndex) { _, _ = s.Rewind(5) }, calls: 1},
+		{name: "reset", move: func(s *SegmentedIndex) { s.Reset() }, calls: 1},
+		{name: "goTo the same position", move: func(s *SegmentedIndex) { s.GoTo(3) }, calls: 0},
+		{name: "goToBatch", move: func(s *SegmentedIndex) { s.GoToBatch([]int64{1, 10}) }, calls: 1},
+		{name: "goToCeil", move: func(s *SegmentedIndex) { s.GoToCeil(5) }, calls: 1},
+		{name: "goToCounting", move: func(s *SegmentedIndex) { s.GoToCounting(10) }, calls: 1},
+		{name: "goToScaled", move: func(s *SegmentedIndex) { s.GoToScaled(5) }, calls: 1},
+		{name: "goToFraction", move: func(s *SegmentedIndex) { _, _ = s.GoToFraction(10, 0.5) }, calls: 1},
+		{name: "rewindToCycleStart at a boundary", move: func(s *SegmentedIndex) { s.RewindToCycleStart() }, calls: 0},
+		{name: "reconfigure", move: func(s *SegmentedIndex) { _, _ = s.Reconfigure(1, 3, []int64{3}) }, calls: 1},
+		{name: "remapUnscaled", move: func(s *SegmentedIndex) { _, _ = s.RemapUnscaled(1) }, calls: 1},
+		{
+			name: "loadState",
+			move: func(s *SegmentedIndex) {
+				_ = s.LoadState(`{"start":0,"lcd":3,"offsets":[1,2],"scaled":3,"unscaled":4}`)
+			},
+			calls: 1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 3, []int64{1, 2})
+			_, _ = index.NextN(2)
+			calls := 0
+			SetOnAdvance(index, func(SegmentedIndexResult) { calls++ })
+			tc.move(index)
+			if calls != tc.calls {
+				t.Fatalf("expected %d calls but got %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestSetOnAdvanceRemoved(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 1, []int64{1})
+	calls := 0
+	SetOnAdvance(index, func(SegmentedIndexResult) { calls++ })
+	index.Next()
+	SetOnAdvance(index, nil)
+	index.Next()
+	if calls != 1 {
+		t.Fatalf("expected 1 call but got %d", calls)
+	}
+}
+
+func TestSetOnAdvanceNotInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	v := runJS(t, rt, `typeof segment.custom(0, 1, [1]).onAdvance`)
+	if v.String() != "undefined" {
+		t.Fatalf("expected onAdvance not to be in JS but it's %s", v)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ClaimResult is the result of ClaimOrWait.
+type ClaimResult struct {
+	SegmentedIndexResult `js:"-"`
+	// TimedOut is set if the timeout passed before the index could be moved, in which case Done
+	// is also set.
+	TimedOut bool `js:"timedOut"`
+}
+
+// Claim takes the next index for the caller as Next does, but always with the index locked
+// exclusively.
+func (s *SegmentedIndex) Claim() SegmentedIndexResult {
+	s.mx.Lock()
+	result := s.next()
+	s.unlockAndNotify(result)
+	return result
+}
+
+// ClaimOrWait does what Claim does, but if Next can't go further, for example because the bound
+// is reached, it waits until it can, as when SetMax raises the bound, for up to timeout. If ctx is
+// done while waiting its error is returned and the index isn't moved.
+func (s *SegmentedIndex) ClaimOrWait(ctx context.Context, timeout time.Duration) (ClaimResult, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	s.mx.Lock()
+	stop := s.broadcastOnDone(waitCtx)
+	result, err := s.claimOrWait(ctx, waitCtx)
+	stop()
+	s.unlockAndNotify(result.SegmentedIndexResult)
+	return result, err
+}
+
+// claimOrWait does what ClaimOrWait does, waiting until waitCtx is done, but must be called with
+// s.mx locked and the waits woken up once waitCtx is done.
+func (s *SegmentedIndex) claimOrWait(ctx, waitCtx context.Context) (ClaimResult, error) {
+	for {
+		result := s.next()
+		if !result.Done {
+			return ClaimResult{SegmentedIndexResult: result}, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return ClaimResult{SegmentedIndexResult: result}, err
+		}
+		if waitCtx.Err() != nil {
+			return ClaimResult{SegmentedIndexResult: result, TimedOut: true}, nil
+		}
+		s.advanced.Wait()
+	}
+}
+
+// ClaimOrWait does what ClaimOrWait does on index for up to timeoutMs milliseconds. It blocks the
+// VU until then, or until the iteration is interrupted.
+func (m *Module) ClaimOrWait(ctx context.Context, index *SegmentedIndex, timeoutMs int64) (ClaimResult, error) {
+	if index == nil {
+		return ClaimResult{}, errors.New("no segmented index provided to claimOrWait")
+	}
+	return index.ClaimOrWait(ctx, time.Duration(timeoutMs)*time.Millisecond)
+}
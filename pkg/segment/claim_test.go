@@ -0,0 +1,146 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// exhaustedIndex returns an index bounded at 4 that has gone through all its unscaled indexes.
+func exhaustedIndex() *SegmentedIndex {
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.SetMax(4)
+	index.NextN(3)
+	return index
+}
+
+func TestClaimOrWaitTimeout(t *testing.T) {
+	t.Parallel()
+	index := exhaustedIndex()
+	const timeout = 20 * time.Millisecond
+	start := time.Now()
+	result, err := index.ClaimOrWait(context.Background(), timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Fatalf("expected ClaimOrWait to wait for %s but it returned after %s", timeout, elapsed)
+	}
+	want := SegmentedIndexResult{Scaled: 3, Unscaled: 4, Done: true}
+	if !result.TimedOut || result.SegmentedIndexResult != want || index.Current() != (SegmentedIndexResult{Scaled: 3, Unscaled: 4}) {
+		t.Fatalf("expected to time out at %+v but got %+v", want, result)
+	}
+}
+
+func TestClaimOrWaitWokenBySetMax(t *testing.T) {
+	t.Parallel()
+	index := exhaustedIndex()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		index.SetMax(10)
+	}()
+	start := time.Now()
+	result, err := index.ClaimOrWait(context.Background(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected raising the bound to wake ClaimOrWait up but it waited for %s", elapsed)
+	}
+	if want := (SegmentedIndexResult{Scaled: 4, Unscaled: 5, Step: 1}); result.TimedOut || result.SegmentedIndexResult != want {
+		t.Fatalf("expected to claim %+v once the bound was raised but got %+v", want, result)
+	}
+}
+
+func TestClaimOrWaitCancelled(t *testing.T) {
+	t.Parallel()
+	index := exhaustedIndex()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	result, err := index.ClaimOrWait(ctx, 10*time.Second)
+	if !errors.Is(err, context.Canceled) || result.TimedOut {
+		t.Fatalf("expected the context error but got %+v, %v", result, err)
+	}
+}
+
+func TestClaimOrWaitManyClaimers(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	const claimers, max = 8, 3000
+	index.SetMax(max)
+	var claimed [max + 1]int32
+	var timedOut int32
+	var wg sync.WaitGroup
+	for i := 0; i < claimers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				result, err := index.ClaimOrWait(context.Background(), 10*time.Millisecond)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if result.TimedOut {
+					atomic.AddInt32(&timedOut, 1)
+					return
+				}
+				atomic.AddInt32(&claimed[result.Unscaled], 1)
+			}
+		}()
+	}
+	wg.Wait()
+	for unscaled := int64(1); unscaled <= max; unscaled++ {
+		want := int32(0)
+		if index.Contains(unscaled) {
+			want = 1
+		}
+		if claimed[unscaled] != want {
+			t.Fatalf("expected %d to be claimed %d times but it was %d times", unscaled, want, claimed[unscaled])
+		}
+	}
+	if timedOut != claimers {
+		t.Fatalf("expected each claimer to time out once the bound was reached but %d did", timedOut)
+	}
+}
+
+func TestClaimOrWaitInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	got := runJS(t, rt, `
+		var index = segment.custom(0, 3, [1, 2]);
+		index.setMax(1);
+		var first = segment.claimOrWait(index, 10);
+		var second = segment.claimOrWait(index, 10);
+		[first.unscaled, first.timedOut, second.done, second.timedOut].join(",")
+	`).String()
+	if want := "1,false,true,true"; got != want {
+		t.Fatalf("expected %s but got %s", want, got)
+	}
+}
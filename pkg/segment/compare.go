@@ -0,0 +1,76 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import "unsafe"
+
+// Equal returns whether other has the same start, lcd, offsets and position as the index.
+func (s *SegmentedIndex) Equal(other *SegmentedIndex) bool {
+	if other == nil {
+		return false
+	}
+	defer rLockBoth(s, other)()
+	if s.start != other.start || s.lcd != other.lcd || len(s.offsets) != len(other.offsets) {
+		return false
+	}
+	for i, offset := range s.offsets {
+		if other.offsets[i] != offset {
+			return false
+		}
+	}
+	return samePosition(s, other)
+}
+
+// SamePosition returns whether other is at the same scaled and unscaled index as the index,
+// regardless of their parameters.
+func (s *SegmentedIndex) SamePosition(other *SegmentedIndex) bool {
+	if other == nil {
+		return false
+	}
+	defer rLockBoth(s, other)()
+	return samePosition(s, other)
+}
+
+// samePosition must be called with both a and b read locked.
+func samePosition(a, b *SegmentedIndex) bool {
+	aScaled, aUnscaled := a.position()
+	bScaled, bUnscaled := b.position()
+	return aScaled == bScaled && aUnscaled == bUnscaled
+}
+
+// rLockBoth read locks a and b and returns a function unlocking them. The locks are always
+// taken in the order of the indexes' addresses, as otherwise a.Equal(b) and b.Equal(a) can
+// deadlock once a writer is waiting on either of them. The same index is locked only once.
+func rLockBoth(a, b *SegmentedIndex) func() {
+	if a == b {
+		a.mx.RLock()
+		return a.mx.RUnlock
+	}
+	if uintptr(unsafe.Pointer(a)) > uintptr(unsafe.Pointer(b)) {
+		a, b = b, a
+	}
+	a.mx.RLock()
+	b.mx.RLock()
+	return func() {
+		b.mx.RUnlock()
+		a.mx.RUnlock()
+	}
+}
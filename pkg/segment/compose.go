@@ -0,0 +1,119 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ComposedIndex nests one SegmentedIndex in another, for example to split the unscaled indexes
+// between regions and then between the VUs in each region. The unscaled indexes are split in
+// blocks as long as the cycle of the inner index, the outer index picks which blocks are owned
+// and the inner one which unscaled indexes in each block are. As unscaled indexes start at 1,
+// the unscaled index for the outer index being at block b and the inner one at i is
+// (b-1)*span + i.
+type ComposedIndex struct {
+	mx           sync.Mutex
+	outer, inner *SegmentedIndex
+	span         int64
+	block        int64 // the unscaled index of the outer index or 0 before the first call to Next
+
+	scaled, unscaled int64
+}
+
+// Compose returns a ComposedIndex going through the blocks of outer and through the unscaled
+// indexes of inner in each of them. Both are cloned and start from the beginning, so neither is
+// moved by the ComposedIndex. A bound set on outer limits the blocks, one on inner is replaced by
+// the span of the block. The inner index must own the same unscaled indexes in every cycle, as
+// the ones made from striped offsets do, so it returns an error if the offsets of inner don't sum
+// up to its lcd or start pushes its last unscaled index in the first cycle past its lcd.
+func Compose(outer, inner *SegmentedIndex) (*ComposedIndex, error) {
+	if outer == nil || inner == nil {
+		return nil, errors.New("both an outer and an inner index are needed")
+	}
+	outer, inner = outer.Clone(), inner.Clone()
+	outer.Reset()
+	inner.Reset()
+	span := inner.lcd
+	if err := checkParameters(span, inner.offsets); err != nil {
+		return nil, fmt.Errorf("invalid inner index %s: %w", inner, err)
+	}
+	// the inner index owns the same unscaled indexes in every block only if all the ones it owns
+	// in its first cycle are in it
+	if last := inner.start + inner.prefixSums[len(inner.offsets)-1] + 1; last > span {
+		return nil, fmt.Errorf("inner index %s owns %d in its first cycle, which is past its lcd", inner, last)
+	}
+	inner.SetMax(span)
+	return &ComposedIndex{outer: outer, inner: inner, span: span}, nil
+}
+
+// Next goes to the next unscaled index of the inner index in the current block, going to the
+// next block of the outer index once the inner one goes through the whole block. Done is set
+// once the outer index is done or the unscaled index would overflow int64.
+func (c *ComposedIndex) Next() SegmentedIndexResult {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for {
+		if c.block != 0 {
+			if r := c.inner.Next(); !r.Done {
+				unscaled := (c.block-1)*c.span + r.Unscaled
+				result := SegmentedIndexResult{Scaled: c.scaled + 1, Unscaled: unscaled, Step: unscaled - c.unscaled}
+				c.scaled, c.unscaled = result.Scaled, result.Unscaled
+				return result
+			}
+		}
+		// as Compose checks the inner index owns an unscaled index in each block, this goes
+		// around at most once more
+		o := c.outer.Next()
+		if o.Done || o.Unscaled-1 > (math.MaxInt64-c.span)/c.span {
+			return SegmentedIndexResult{Scaled: c.scaled, Unscaled: c.unscaled, Done: true}
+		}
+		c.block = o.Unscaled
+		c.inner.Reset()
+	}
+}
+
+// Current returns the current scaled and unscaled index without changing them.
+func (c *ComposedIndex) Current() SegmentedIndexResult {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return SegmentedIndexResult{Scaled: c.scaled, Unscaled: c.unscaled}
+}
+
+// Reset goes back to the start as if Next was never called.
+func (c *ComposedIndex) Reset() SegmentedIndexResult {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.outer.Reset()
+	c.inner.Reset()
+	c.block, c.scaled, c.unscaled = 0, 0, 0
+	return SegmentedIndexResult{}
+}
+
+// Compose returns a ComposedIndex of outer and inner as Compose does.
+func (m *Module) Compose(ctx context.Context, outer, inner *SegmentedIndex) (_ *ComposedIndex, err error) {
+	defer m.handleError(ctx, &err)
+	return Compose(outer, inner)
+}
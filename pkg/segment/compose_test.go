@@ -0,0 +1,153 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestComposeRollover(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name         string
+		outer, inner *SegmentedIndex
+		outerMax     int64 // no bound if not positive
+		want         []int64
+	}{
+		{
+			name:  "whole outer",
+			outer: NewSegmentedIndex(0, 1, []int64{1}), inner: NewSegmentedIndex(0, 3, []int64{1, 2}),
+			want: []int64{1, 2, 4, 5, 7, 8},
+		},
+		{
+			name:  "every other block",
+			outer: NewSegmentedIndex(0, 2, []int64{2}), inner: NewSegmentedIndex(1, 3, []int64{3}),
+			want: []int64{2, 8, 14, 20},
+		},
+		{
+			name:  "many in each block",
+			outer: NewSegmentedIndex(1, 2, []int64{2}), inner: NewSegmentedIndex(0, 3, []int64{1, 2}),
+			want: []int64{4, 5, 10, 11, 16, 17},
+		},
+		{
+			name:  "inner at the end of its cycle",
+			outer: NewSegmentedIndex(0, 1, []int64{1}), inner: NewSegmentedIndex(2, 3, []int64{3}),
+			want: []int64{3, 6, 9},
+		},
+		{
+			name:  "bounded outer",
+			outer: NewSegmentedIndex(0, 2, []int64{2}), inner: NewSegmentedIndex(0, 3, []int64{1, 2}),
+			outerMax: 3, want: []int64{1, 2, 7, 8},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if tc.outerMax > 0 {
+				tc.outer.SetMax(tc.outerMax)
+			}
+			composed, err := Compose(tc.outer, tc.inner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for i := range tc.want {
+				result := composed.Next()
+				if result.Done || result.Scaled != int64(i+1) {
+					t.Fatalf("expected Next to go to scaled index %d but got %+v", i+1, result)
+				}
+				got = append(got, result.Unscaled)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Fatalf("expected %v but got %v", tc.want, got)
+			}
+			if tc.outerMax > 0 {
+				if result := composed.Next(); !result.Done || result.Unscaled != tc.want[len(tc.want)-1] {
+					t.Fatalf("expected Next to be done after the last block but got %+v", result)
+				}
+			}
+			if tc.outer.GetScaled() != 0 || tc.inner.GetScaled() != 0 {
+				t.Fatal("expected the composed indexes not to be moved")
+			}
+		})
+	}
+}
+
+func TestComposeReset(t *testing.T) {
+	t.Parallel()
+	composed, err := Compose(NewSegmentedIndex(0, 2, []int64{2}), NewSegmentedIndex(0, 3, []int64{1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := composed.Next()
+	for i := 0; i < 3; i++ {
+		composed.Next()
+	}
+	if got := composed.Reset(); got != (SegmentedIndexResult{}) || composed.Current() != got {
+		t.Fatalf("expected Reset to go back to the start but got %+v", got)
+	}
+	if got := composed.Next(); got != first {
+		t.Fatalf("expected Next to start over from %+v but got %+v", first, got)
+	}
+}
+
+func TestComposeErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name         string
+		outer, inner *SegmentedIndex
+	}{
+		{name: "no outer", inner: NewSegmentedIndex(0, 1, []int64{1})},
+		{name: "no inner", outer: NewSegmentedIndex(0, 1, []int64{1})},
+		{name: "inner owning nothing", outer: NewSegmentedIndex(0, 1, []int64{1}), inner: NewSegmentedIndex(0, 3, []int64{})},
+		{
+			name:  "inner owning past its first cycle",
+			outer: NewSegmentedIndex(0, 1, []int64{1}), inner: NewSegmentedIndex(2, 4, []int64{3, 1}),
+		},
+		{
+			name:  "inner offsets not summing up to its lcd",
+			outer: NewSegmentedIndex(0, 1, []int64{1}), inner: NewSegmentedIndex(0, 4, []int64{1, 2}),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if _, err := Compose(tc.outer, tc.inner); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestComposeInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	got := runJS(t, rt, `
+		var composed = segment.compose(segment.custom(0, 2, [2]), segment.custom(0, 3, [1, 2]));
+		[composed.next().unscaled, composed.next().unscaled, composed.next().unscaled].join(",")
+	`).String()
+	if got != "1,2,7" {
+		t.Fatalf("expected 1,2,7 but got %s", got)
+	}
+}
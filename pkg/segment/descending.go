@@ -0,0 +1,61 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+// DescendingSegmentedIndex goes through the same unscaled indexes as a SegmentedIndex up to
+// a maximum but from the biggest one down.
+type DescendingSegmentedIndex struct {
+	// index is at the position after the one last returned by Next
+	index *SegmentedIndex
+}
+
+// Descending returns a DescendingSegmentedIndex whose Next starts from the biggest unscaled
+// index that is smaller or equal to unscaledMax and goes down to the first one.
+func (s *SegmentedIndex) Descending(unscaledMax int64) *DescendingSegmentedIndex {
+	index := s.Clone()
+	index.SetMax(-1)
+	index.mx.Lock()
+	defer index.mx.Unlock()
+	scaled, _ := index.goToPosition(unscaledMax)
+	if scaled > 0 { // otherwise there is nothing to go through
+		scaled++
+	}
+	index.scaled, index.unscaled = scaled, index.unscaledAt(scaled)
+	return &DescendingSegmentedIndex{index: index}
+}
+
+// Next goes to the previous scaled index and moves the unscaled one accordingly. Once the first
+// index has been returned, it returns it again with Done set.
+func (d *DescendingSegmentedIndex) Next() SegmentedIndexResult {
+	d.index.mx.Lock()
+	defer d.index.mx.Unlock()
+	if d.index.scaled <= 1 {
+		return SegmentedIndexResult{Scaled: d.index.scaled, Unscaled: d.index.unscaled, Done: true}
+	}
+	result, _ := d.index.prev() // can't fail as scaled is above 0
+	return result
+}
+
+// Current returns the scaled and unscaled index last returned by Next. Before the first call
+// to Next it's the position after the one Next will return.
+func (d *DescendingSegmentedIndex) Current() SegmentedIndexResult {
+	return d.index.Current()
+}
@@ -0,0 +1,50 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import "testing"
+
+func TestDescendingMirrorsNext(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			for max := int64(0); max <= 2*index.lcd+1; max++ {
+				var ascending []int64
+				for stepped := index.Clone(); ; {
+					result := stepped.Next()
+					if result.Unscaled > max {
+						break
+					}
+					ascending = append(ascending, result.Unscaled)
+				}
+				descending := index.Descending(max)
+				for i := len(ascending) - 1; i >= 0; i-- {
+					if result := descending.Next(); result.Done || result.Unscaled != ascending[i] {
+						t.Fatalf("descending from %d on %s of %q expected %d but got %+v", max, index, sequence, ascending[i], result)
+					}
+				}
+				if result := descending.Next(); !result.Done {
+					t.Fatalf("descending from %d on %s of %q expected to be done but got %+v", max, index, sequence, result)
+				}
+			}
+		}
+	}
+}
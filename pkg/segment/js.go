@@ -0,0 +1,65 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+)
+
+// toJSIndex wraps index in a JS object that also implements the JS iterable protocol, so that
+// `for (const v of index)` calls Next until it returns a result with Done set. All iterators
+// share the same index so no value is returned by more than one of them.
+// As it's what every constructor returns with, it also returns ctx.Err() if ctx is done, so that
+// a constructor called from an aborted iteration fails instead of returning an index.
+func toJSIndex(ctx context.Context, index *SegmentedIndex) (*goja.Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rt := common.GetRuntime(ctx)
+	if rt == nil {
+		return nil, errors.New("no js runtime in the context")
+	}
+	obj := rt.ToValue(index).ToObject(rt)
+	err := obj.SetSymbol(goja.SymIterator, func(goja.FunctionCall) goja.Value {
+		iterator := rt.NewObject()
+		_ = iterator.Set("next", func(goja.FunctionCall) goja.Value {
+			result := rt.NewObject()
+			v := index.Next()
+			if v.Done {
+				_ = result.Set("done", true)
+				_ = result.Set("value", goja.Undefined())
+			} else {
+				_ = result.Set("done", false)
+				_ = result.Set("value", v)
+			}
+			return result
+		})
+		return iterator
+	})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,131 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib"
+)
+
+// newTestRuntime returns a runtime with a new Module bound as segment, as k6 binds it, in a VU
+// context with the given execution segment and sequence, which are not set if empty.
+func newTestRuntime(tb testing.TB, segment, sequence string) (*goja.Runtime, *Module) {
+	tb.Helper()
+	m := New()
+	return bindTestModule(tb, m, segment, sequence), m
+}
+
+// bindTestModule is newTestRuntime for the given Module.
+func bindTestModule(tb testing.TB, m *Module, segment, sequence string) *goja.Runtime {
+	tb.Helper()
+	rt := goja.New()
+	rt.SetFieldNameMapper(common.FieldNameMapper{})
+	ctx := common.WithRuntime(context.Background(), rt)
+	ctx = lib.WithState(ctx, testState(tb, segment, sequence))
+	if err := rt.Set("segment", common.Bind(rt, m, &ctx)); err != nil {
+		tb.Fatal(err)
+	}
+	return rt
+}
+
+// testState returns the state of VU 1 with the given execution segment and sequence, which are
+// not set if empty.
+func testState(tb testing.TB, segment, sequence string) *lib.State {
+	tb.Helper()
+	var opts lib.Options
+	if segment != "" {
+		es, err := lib.NewExecutionSegmentFromString(segment)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		opts.ExecutionSegment = es
+	}
+	if sequence != "" {
+		ess, err := lib.NewExecutionSegmentSequenceFromString(sequence)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		opts.ExecutionSegmentSequence = &ess
+	}
+	return &lib.State{Options: opts, Vu: 1}
+}
+
+// runJS runs script in rt and returns its result, failing the test if it throws.
+func runJS(tb testing.TB, rt *goja.Runtime, script string) goja.Value {
+	tb.Helper()
+	v, err := rt.RunString(script)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return v
+}
+
+func TestResultFieldNamesInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	got := runJS(t, rt, `
+		var result = segment.custom(0, 3, [1, 2]).next();
+		[Object.keys(result).sort().join(","), result.Scaled === undefined, result.unscaled].join(" ")
+	`).String()
+	if want := "done,scaled,step,unscaled true 1"; got != want {
+		t.Fatalf("expected %q but got %q", want, got)
+	}
+}
+
+func TestForEachInJS(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name, callback string
+	}{
+		{name: "returning a number", callback: `function(s, u) { a.push(u); return a.length }`},
+		{name: "returning nothing", callback: `function(s, u) { a.push(u) }`},
+		{name: "returning push", callback: `function(s, u) { return a.push(u) }`},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rt, _ := newTestRuntime(t, "", "")
+			got := runJS(t, rt, `var a = []; segment.custom(0, 3, [1, 2]).forEach(8, `+tc.callback+`); a.join(",")`).String()
+			if want := "1,2,4,5,7,8"; got != want {
+				t.Fatalf("expected %s but got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestForEachThrowingInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	got := runJS(t, rt, `
+		var index = segment.custom(0, 3, [1, 2]);
+		var message;
+		try { index.forEach(8, function(s, u) { if (u == 2) throw new Error("stop") }) } catch (e) { message = e.message }
+		message + " " + index.current().unscaled
+	`).String()
+	if want := "stop 2"; got != want {
+		t.Fatalf("expected the exception to stop forEach with %q but got %q", want, got)
+	}
+}
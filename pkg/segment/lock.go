@@ -0,0 +1,98 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LockMode is how a SegmentedIndex is locked.
+type LockMode int
+
+const (
+	// LockRW locks the index with a sync.RWMutex so that the methods only reading it, like
+	// Current and Peek, can be called concurrently. It's the default.
+	LockRW LockMode = iota
+	// LockExclusive locks the index with a sync.Mutex instead, which is cheaper when the index is
+	// mostly moved by Next, but the methods only reading it also lock it exclusively.
+	LockExclusive
+)
+
+// ParseLockMode returns the LockMode for "rw" or "mutex".
+func ParseLockMode(mode string) (LockMode, error) {
+	switch mode {
+	case "rw":
+		return LockRW, nil
+	case "mutex":
+		return LockExclusive, nil
+	default:
+		return 0, fmt.Errorf(`lockMode must be "rw" or "mutex" but is %q`, mode)
+	}
+}
+
+// indexLock is what a SegmentedIndex is locked with. It locks either a sync.RWMutex or, if
+// exclusive is set, a sync.Mutex for both the read and the write locks. The zero value is in
+// LockRW mode and exclusive mustn't be changed once the index is in use.
+type indexLock struct {
+	exclusive bool
+	rw        sync.RWMutex
+	m         sync.Mutex
+}
+
+func (l *indexLock) Lock() {
+	if l.exclusive {
+		l.m.Lock()
+		return
+	}
+	l.rw.Lock()
+}
+
+func (l *indexLock) Unlock() {
+	if l.exclusive {
+		l.m.Unlock()
+		return
+	}
+	l.rw.Unlock()
+}
+
+func (l *indexLock) TryLock() bool {
+	if l.exclusive {
+		return l.m.TryLock()
+	}
+	return l.rw.TryLock()
+}
+
+func (l *indexLock) RLock() {
+	if l.exclusive {
+		l.m.Lock()
+		return
+	}
+	l.rw.RLock()
+}
+
+func (l *indexLock) RUnlock() {
+	if l.exclusive {
+		l.m.Unlock()
+		return
+	}
+	l.rw.RUnlock()
+}
@@ -0,0 +1,52 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import "testing"
+
+func BenchmarkLockMode(b *testing.B) {
+	modes := []struct {
+		name string
+		mode LockMode
+	}{
+		{name: "rw", mode: LockRW},
+		{name: "mutex", mode: LockExclusive},
+	}
+	for _, m := range modes {
+		m := m
+		b.Run(m.name, func(b *testing.B) {
+			index := NewSegmentedIndexWithLockMode(0, 10, []int64{1, 2, 3, 4}, m.mode)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index.Next()
+			}
+		})
+		b.Run(m.name+" parallel", func(b *testing.B) {
+			index := NewSegmentedIndexWithLockMode(0, 10, []int64{1, 2, 3, 4}, m.mode)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					index.Next()
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/stats"
+)
+
+// MeteredSegmentedIndex is a SegmentedIndex that adds to a counter metric each time it is advanced
+// with Next or NextN.
+type MeteredSegmentedIndex struct {
+	*SegmentedIndex
+	metric *stats.Metric
+	ctxPtr *context.Context
+}
+
+// WithMetric wraps index so that each time it's advanced a sample of a counter metric with the
+// given name is emitted. Other holders of index don't emit samples.
+func (m *Module) WithMetric(ctxPtr *context.Context, index *SegmentedIndex, name string) (*MeteredSegmentedIndex, error) {
+	if index == nil {
+		return nil, errors.New("no segmented index provided to withMetric")
+	}
+	if len(name) == 0 {
+		return nil, errors.New("empty metric name provided to withMetric")
+	}
+	return &MeteredSegmentedIndex{
+		SegmentedIndex: index,
+		metric:         stats.New(name, stats.Counter),
+		ctxPtr:         ctxPtr,
+	}, nil
+}
+
+// Next calls Next on the underlying index and emits a sample if it advanced.
+func (s *MeteredSegmentedIndex) Next() SegmentedIndexResult {
+	result := s.SegmentedIndex.Next()
+	if !result.Done {
+		s.push(1)
+	}
+	return result
+}
+
+// NextN calls NextN on the underlying index and emits a sample with how much it advanced.
+func (s *MeteredSegmentedIndex) NextN(count int64) ([]SegmentedIndexResult, error) {
+	results, err := s.SegmentedIndex.NextN(count)
+	if len(results) > 0 {
+		s.push(float64(len(results)))
+	}
+	return results, err
+}
+
+func (s *MeteredSegmentedIndex) push(value float64) {
+	ctx := *s.ctxPtr
+	state := lib.GetState(ctx)
+	if state == nil { // samples can't be emitted in the init context
+		return
+	}
+	tags := state.CloneTags()
+	stats.PushIfNotDone(ctx, state.Samples, stats.Sample{
+		Time:   time.Now(),
+		Metric: s.metric,
+		Value:  value,
+		Tags:   stats.IntoSampleTags(&tags),
+	})
+}
@@ -0,0 +1,98 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+
+	"go.k6.io/k6/js/common"
+)
+
+// Options configure the defaults of a Module. The zero value is what New uses.
+type Options struct {
+	// SharedShards is the number of shards the shared indexes are split between.
+	// If it is not positive sharedShards is used.
+	SharedShards int
+
+	// PanicOnError makes the constructors throw their errors as JS exceptions, or panic with
+	// them if there is no JS runtime, instead of returning them.
+	PanicOnError bool
+
+	// DefaultMax bounds every new index as SetMax(DefaultMax) would. If it is not positive new
+	// indexes are not bounded.
+	DefaultMax int64
+}
+
+// NewWithOptions returns a new Module configured with opts.
+func NewWithOptions(opts Options) *Module {
+	shards := opts.SharedShards
+	if shards <= 0 {
+		shards = sharedShards
+	}
+	return &Module{
+		ResultFields: ResultFieldNames{Scaled: "scaled", Unscaled: "unscaled", Done: "done", Step: "step"},
+		Done:         "done",
+		Unbounded:    -1,
+
+		opts: opts,
+		shared: sharedSegmentedIndexes{
+			data: newShardedMap(shards),
+			max:  opts.DefaultMax,
+		},
+		vus: vuSegmentedIndexes{
+			data: make(map[int64]*SegmentedIndex),
+			max:  opts.DefaultMax,
+		},
+		striped: stripedOffsetsCache{
+			data: make(map[string]stripedOffsets),
+		},
+		cursors: sharedCursors{
+			data: make(map[*SegmentedIndex]map[string]*SegmentedIndex),
+			max:  opts.DefaultMax,
+		},
+		scopes: sharedScopes{
+			data:   make(map[string]*sharedSegmentedIndexes),
+			shards: shards,
+			max:    opts.DefaultMax,
+		},
+	}
+}
+
+// handleError is deferred by the constructors with their error result and throws or panics
+// with it if PanicOnError is set.
+func (m *Module) handleError(ctx context.Context, err *error) {
+	if *err == nil || !m.opts.PanicOnError {
+		return
+	}
+	if rt := common.GetRuntime(ctx); rt != nil {
+		common.Throw(rt, *err)
+	}
+	panic(*err)
+}
+
+// withDefaultMax bounds index by unscaledMax if it is positive and returns it.
+func withDefaultMax(index *SegmentedIndex, unscaledMax int64) *SegmentedIndex {
+	if unscaledMax > 0 {
+		index.max, index.bounded = unscaledMax, true
+		index.updateFastNext()
+	}
+	return index
+}
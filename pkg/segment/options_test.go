@@ -0,0 +1,115 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+)
+
+func TestPanicOnError(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name         string
+		panicOnError bool
+		runtime      bool
+		want         string // what the constructor does with errNoState
+	}{
+		{name: "returned", want: "returned"},
+		{name: "returned with a runtime", runtime: true, want: "returned"},
+		{name: "panicked", panicOnError: true, want: "panicked"},
+		{name: "thrown", panicOnError: true, runtime: true, want: "thrown"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			if tc.runtime {
+				ctx = common.WithRuntime(ctx, goja.New())
+			}
+			m := NewWithOptions(Options{PanicOnError: tc.panicOnError})
+			got := func() (got string) {
+				defer func() {
+					switch r := recover().(type) {
+					case nil:
+					case *goja.Object: // what common.Throw panics with for JS to throw
+						got = "thrown"
+					case error:
+						if errors.Is(r, errNoState) {
+							got = "panicked"
+						}
+					}
+				}()
+				if _, err := m.XSegmentedIndex(ctx); errors.Is(err, errNoState) {
+					return "returned"
+				}
+				return "nothing"
+			}()
+			if got != tc.want {
+				t.Fatalf("expected the error to be %s but it was %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDefaultMax(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name       string
+		defaultMax int64
+		want       int64
+	}{
+		{name: "unbounded", defaultMax: 0, want: 4},
+		{name: "bounded", defaultMax: 4, want: 3},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rt := bindTestModule(t, NewWithOptions(Options{DefaultMax: tc.defaultMax}), "", "")
+			if got := runJS(t, rt, `segment.custom(0, 3, [1, 2]).nextN(4).length`).ToInteger(); got != tc.want {
+				t.Fatalf("expected %d results but got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSharedShards(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		shards, want int
+	}{
+		{shards: 0, want: sharedShards},
+		{shards: -1, want: sharedShards},
+		{shards: 3, want: 3},
+	}
+	for _, tc := range testCases {
+		m := NewWithOptions(Options{SharedShards: tc.shards})
+		if got := len(m.shared.data.shards); got != tc.want {
+			t.Fatalf("expected %d shards for SharedShards %d but got %d", tc.want, tc.shards, got)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// NextPaced does what Next does but not before interval has passed since the previous call to
+// NextPaced advanced the index, blocking until then. Concurrent calls each wait for their own
+// interval, so the index advances at most once per interval. If ctx is done while waiting its
+// error is returned and the index isn't advanced.
+func (s *SegmentedIndex) NextPaced(ctx context.Context, interval time.Duration) (SegmentedIndexResult, error) {
+	s.mx.Lock()
+	at := time.Now()
+	if next := s.pacedAt.Add(interval); !s.pacedAt.IsZero() && next.After(at) {
+		at = next
+	}
+	s.pacedAt = at
+	s.mx.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return s.Current(), ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return s.Next(), nil
+}
+
+// NextPaced does what Next does on index but not before intervalMs milliseconds have passed since
+// the previous call to nextPaced advanced it. It blocks the VU until then, or until the iteration
+// is interrupted.
+func (m *Module) NextPaced(ctx context.Context, index *SegmentedIndex, intervalMs int64) (SegmentedIndexResult, error) {
+	if index == nil {
+		return SegmentedIndexResult{}, errors.New("no segmented index provided to nextPaced")
+	}
+	return index.NextPaced(ctx, time.Duration(intervalMs)*time.Millisecond)
+}
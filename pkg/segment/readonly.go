@@ -0,0 +1,53 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+// ReadOnlyView gives access to the position of a SegmentedIndex without a way to move it, so it
+// can be passed to code that must not advance the index. It reads the index it was made from,
+// so it sees every change to it.
+type ReadOnlyView struct {
+	index *SegmentedIndex
+}
+
+// ReadOnly returns a ReadOnlyView of the index.
+func (s *SegmentedIndex) ReadOnly() *ReadOnlyView {
+	return &ReadOnlyView{index: s}
+}
+
+// Current returns the current scaled and unscaled index of the index.
+func (v *ReadOnlyView) Current() SegmentedIndexResult {
+	return v.index.Current()
+}
+
+// Peek returns what Next on the index would return.
+func (v *ReadOnlyView) Peek() SegmentedIndexResult {
+	return v.index.Peek()
+}
+
+// GetScaled returns the current scaled index of the index.
+func (v *ReadOnlyView) GetScaled() int64 {
+	return v.index.GetScaled()
+}
+
+// GetUnscaled returns the current unscaled index of the index.
+func (v *ReadOnlyView) GetUnscaled() int64 {
+	return v.index.GetUnscaled()
+}
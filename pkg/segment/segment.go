@@ -23,128 +23,1398 @@ package segment
 import (
 	"context"
 	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/dop251/goja"
 	"go.k6.io/k6/lib"
 )
 
+var errPrevAtStart = errors.New("can't go to the previous index as the index is at its start")
+
+var errFrozen = errors.New("index is frozen")
+
 // SegmentedIndex ...
 type SegmentedIndex struct {
 	start, lcd       int64
 	offsets          []int64
-	mx               sync.RWMutex
+	prefixSums       []int64 // prefixSums[i] is the sum of the first i offsets
+	mx               indexLock
 	scaled, unscaled int64 // for both the first element(vu) is 1 not 0
+
+	max     int64 // the biggest unscaled index Next will go to if bounded is set
+	bounded bool
+
+	initialScaled int64 // as set by SetInitialScaled
+
+	advanced *sync.Cond // broadcasted on when the unscaled index moves if there are waiters
+
+	pacedAt time.Time // when the last call to NextPaced advances the index
+
+	frozen bool // as set by Freeze
+
+	onAdvance func(SegmentedIndexResult) // as set by SetOnAdvance
+
+	fastNext int32 // 1 if Next can take its fast path, as set by updateFastNext
 }
 
+// Module is the k6/x/segment JS module. Its exported fields are constants common.Bind exports to
+// JS with the names in their tags.
 type Module struct {
-	shared sharedSegmentedIndexes
+	// ResultFields are the names of the fields of the results of Next and the other methods
+	// returning a position.
+	ResultFields ResultFieldNames `js:"RESULT_FIELDS"`
+	// Done is the name of the field of a result that is set once Next can't go further.
+	Done string `js:"DONE"`
+	// Unbounded is what SetMax removes the bound of an index with.
+	Unbounded int64 `js:"UNBOUNDED"`
+
+	opts    Options
+	shared  sharedSegmentedIndexes
+	vus     vuSegmentedIndexes
+	striped stripedOffsetsCache
+	cursors sharedCursors
+	scopes  sharedScopes
+}
+
+// ResultFieldNames are the names the fields of a SegmentedIndexResult have in JS.
+type ResultFieldNames struct {
+	Scaled   string `js:"SCALED"`
+	Unscaled string `js:"UNSCALED"`
+	Done     string `js:"DONE"`
+	Step     string `js:"STEP"`
 }
 
-type sharedSegmentedIndexes struct {
-	data map[string]*SegmentedIndex
+// stripedOffsets are the results of GetStripedOffsets for a given ExecutionTuple.
+// The offsets are shared between all users and must not be modified.
+type stripedOffsets struct {
+	start, lcd int64
+	offsets    []int64
+}
+
+// stripedOffsetsCache caches the stripedOffsets by the segment and sequence they are for,
+// so that the ExecutionTuple doesn't need to be built again for each new SegmentedIndex.
+type stripedOffsetsCache struct {
+	data map[string]stripedOffsets
 	mu   sync.RWMutex
 }
 
-func (s *sharedSegmentedIndexes) get(state *lib.State, name string) *SegmentedIndex {
-	s.mu.RLock()
-	array, ok := s.data[name]
-	s.mu.RUnlock()
-	if !ok {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-		array, ok = s.data[name]
-		if !ok {
-			// cache those
-			tuple, err := lib.NewExecutionTuple(state.Options.ExecutionSegment, state.Options.ExecutionSegmentSequence)
-			if err != nil {
-				panic(err)
-			}
-			start, offsets, lcd := tuple.GetStripedOffsets()
+// tupleIdentity returns a string identifying the execution segment and sequence in state.
+func tupleIdentity(state *lib.State) string {
+	identity := state.Options.ExecutionSegment.String() + "@"
+	if state.Options.ExecutionSegmentSequence != nil {
+		identity += state.Options.ExecutionSegmentSequence.String()
+	}
+	return identity
+}
+
+// buildStripedIndex returns a new SegmentedIndex striped for the execution segment and sequence
+// in state.
+func buildStripedIndex(state *lib.State) (*SegmentedIndex, error) {
+	tuple, err := lib.NewExecutionTuple(state.Options.ExecutionSegment, state.Options.ExecutionSegmentSequence)
+	if err != nil {
+		return nil, err
+	}
+	start, offsets, lcd := tuple.GetStripedOffsets()
+	return NewSegmentedIndex(start, lcd, offsets), nil
+}
+
+func (c *stripedOffsetsCache) get(state *lib.State) (stripedOffsets, error) {
+	key := tupleIdentity(state)
+	c.mu.RLock()
+	striped, ok := c.data[key]
+	c.mu.RUnlock()
+	if ok {
+		return striped, nil
+	}
+
+	index, err := buildStripedIndex(state)
+	if err != nil {
+		return stripedOffsets{}, err
+	}
+	striped = stripedOffsets{start: index.start, lcd: index.lcd, offsets: index.offsets}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = striped
+	return striped, nil
+}
 
-			array = NewSegmentedIndex(start, lcd, offsets)
-			s.data[name] = array
+// vuSegmentedIndexes holds one SegmentedIndex per VU for the lifetime of the VU.
+type vuSegmentedIndexes struct {
+	data map[int64]*SegmentedIndex
+	mu   sync.Mutex
+	max  int64 // the default bound of new indexes
+}
+
+func (v *vuSegmentedIndexes) get(state *lib.State) (*SegmentedIndex, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	index, ok := v.data[state.Vu]
+	if !ok {
+		var err error
+		index, err = buildStripedIndex(state)
+		if err != nil {
+			return nil, err
 		}
+		index = withDefaultMax(index, v.max)
+		v.data[state.Vu] = index
 	}
 
-	return array
+	return index, nil
 }
 
+// New returns a new Module with the default Options.
 func New() *Module {
-	return &Module{
-		shared: sharedSegmentedIndexes{
-			data: make(map[string]*SegmentedIndex),
-		},
-	}
+	return NewWithOptions(Options{})
 }
 
-func (m *Module) XSegmentedIndex(ctx context.Context) *SegmentedIndex {
+var errNoState = errors.New("segmented index can only be created in the VU/iteration context")
+
+// getState returns the state from ctx or an error if there isn't one, as in the init context.
+func getState(ctx context.Context) (*lib.State, error) {
 	state := lib.GetState(ctx)
-	// TODO check state ;)
+	if state == nil {
+		return nil, errNoState
+	}
+	return state, nil
+}
 
-	// cache those
-	tuple, err := lib.NewExecutionTuple(state.Options.ExecutionSegment, state.Options.ExecutionSegmentSequence)
+func (m *Module) XSegmentedIndex(ctx context.Context) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	striped, err := m.striped.get(state)
+	if err != nil {
+		return nil, err
 	}
-	start, offsets, lcd := tuple.GetStripedOffsets()
 
-	return NewSegmentedIndex(start, lcd, offsets)
+	index := NewSegmentedIndex(striped.start, striped.lcd, striped.offsets)
+	return toJSIndex(ctx, withDefaultMax(index, m.opts.DefaultMax))
 }
 
-func (m *Module) XSharedSegmentedIndex(ctx context.Context, name string) *SegmentedIndex {
-	state := lib.GetState(ctx)
-	// TODO check state ;)
+func (m *Module) XSharedSegmentedIndex(ctx context.Context, name string) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("empty name provided to SharedSegmentedIndex's constructor")
+	}
+
+	index, err := m.shared.get(state, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toJSIndex(ctx, index)
+}
+
+// SharedSegmentedIndexExResult is what the SharedSegmentedIndexEx constructor returns.
+type SharedSegmentedIndexExResult struct {
+	Index *goja.Object `js:"index"`
+	// Created is true only for the call that created the index.
+	Created bool `js:"created"`
+}
+
+// XSharedSegmentedIndexEx is like the SharedSegmentedIndex constructor but also returns whether
+// the index was created by this call, so that one time setup can be done only once.
+func (m *Module) XSharedSegmentedIndexEx(
+	ctx context.Context, name string,
+) (_ *SharedSegmentedIndexExResult, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("empty name provided to SharedSegmentedIndexEx's constructor")
+	}
+
+	index, created, err := m.shared.getOrCreate(state, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := toJSIndex(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedSegmentedIndexExResult{Index: obj, Created: created}, nil
+}
+
+// Shared is like the SharedSegmentedIndex constructor but creates the index with the given
+// options if it doesn't exist. If it does, the options are ignored with a warning if they differ
+// from the ones it was created with.
+func (m *Module) Shared(ctx context.Context, name string, opts sharedOptions) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("empty name provided to shared")
+	}
+
+	index, err := m.shared.get(state, name, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return toJSIndex(ctx, index)
+}
+
+// InitShared creates the shared index with the given name and moves it as GoTo(value) would
+// before any other call can get it, returning true. If the index already exists it isn't changed
+// and false is returned, so that only the first of many VUs seeding the index does.
+func (m *Module) InitShared(ctx context.Context, name string, value int64) (bool, error) {
+	state, err := getState(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if len(name) == 0 {
+		return false, errors.New("empty name provided to initShared")
+	}
+
+	_, created, err := m.shared.getOrCreate(state, name, nil, func(index *SegmentedIndex) {
+		index.GoTo(value)
+	})
+	return created, err
+}
+
+// ScopedShared is like the SharedSegmentedIndex constructor but the index is only shared within
+// the current scenario, which is taken from the scenario tag, until CloseScope is called for it.
+func (m *Module) ScopedShared(ctx context.Context, name string) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(name) == 0 {
-		panic(errors.New("empty name provided to SharedArray's constructor"))
+		return nil, errors.New("empty name provided to scopedShared")
+	}
+	scenario, ok := state.Tags["scenario"]
+	if !ok {
+		return nil, errors.New("scopedShared needs the scenario system tag to be enabled")
+	}
+
+	index, err := m.scopes.get(scenario).get(state, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toJSIndex(ctx, index)
+}
+
+// CloseScope removes all the shared indexes ScopedShared created for the given scenario,
+// returning how many there were. Indexes already returned keep working, but new calls to
+// ScopedShared from the scenario get new indexes.
+func (m *Module) CloseScope(scenario string) int {
+	return m.scopes.close(scenario)
+}
+
+// Cursor returns the cursor with the given name over the shared index with the given name,
+// creating either of them if needed. A cursor has the same parameters as the shared index but
+// its own position, so each cursor goes through all the indexes independently of the others.
+func (m *Module) Cursor(ctx context.Context, indexName, cursorName string) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(indexName) == 0 {
+		return nil, errors.New("empty index name provided to cursor")
+	}
+	if len(cursorName) == 0 {
+		return nil, errors.New("empty cursor name provided to cursor")
+	}
+
+	index, err := m.shared.get(state, indexName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return toJSIndex(ctx, m.cursors.get(index, cursorName))
+}
+
+// XVUSegmentedIndex returns the SegmentedIndex of the current VU, creating it on the first call.
+// The index is kept for the lifetime of the VU, so calls from different iterations of the same
+// VU keep advancing the same index.
+func (m *Module) XVUSegmentedIndex(ctx context.Context) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	state, err := getState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := m.vus.get(state)
+	if err != nil {
+		return nil, err
+	}
+	return toJSIndex(ctx, index)
+}
+
+// FromString returns a new SegmentedIndex for the given execution segment and sequence strings
+// instead of the ones the test is running with. An empty sequence means the default one.
+func (m *Module) FromString(ctx context.Context, segment, sequence string) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	start, lcd, offsets, err := StripedOffsetsFromStrings(segment, sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	return toJSIndex(ctx, withDefaultMax(NewSegmentedIndex(start, lcd, offsets), m.opts.DefaultMax))
+}
+
+// SegmentInfo is the position of an execution segment in its sequence.
+type SegmentInfo struct {
+	// Index is the index of the segment in the sequence, which has Count segments.
+	Index int `js:"index"`
+	Count int `js:"count"`
+	// From and To are the start and end of the segment as fractions, as in "1/4".
+	From string `js:"from"`
+	To   string `js:"to"`
+}
+
+// SegmentInfo returns the position of the execution segment of the test in its sequence,
+// or an error if there is no execution segment configured.
+func (m *Module) SegmentInfo(ctx context.Context) (SegmentInfo, error) {
+	state, err := getState(ctx)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	if state.Options.ExecutionSegment == nil {
+		return SegmentInfo{}, errors.New("no execution segment is configured")
+	}
+
+	tuple, err := lib.NewExecutionTuple(state.Options.ExecutionSegment, state.Options.ExecutionSegmentSequence)
+	if err != nil {
+		return SegmentInfo{}, err
+	}
+	info := SegmentInfo{Index: tuple.SegmentIndex, Count: len(tuple.Sequence.ExecutionSegmentSequence)}
+	// there is no other way to get the from and to of an ExecutionSegment
+	info.From, info.To = splitSegment(tuple.Segment.String())
+	return info, nil
+}
+
+// Fraction returns the share of the whole test the execution segment of the test is, as in 0.25
+// for 1/4, which is 1 if there is no execution segment configured.
+func (m *Module) Fraction(ctx context.Context) (float64, error) {
+	state, err := getState(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return state.Options.ExecutionSegment.FloatLength(), nil
+}
+
+// splitSegment splits the string of an ExecutionSegment in its from and to.
+func splitSegment(segment string) (from, to string) {
+	i := strings.IndexByte(segment, ':')
+	return segment[:i], segment[i+1:]
+}
+
+// StripedOffsetsFromStrings returns the parameters for NewSegmentedIndex for the given execution
+// segment and sequence strings, as k6 would parse them from the options.
+func StripedOffsetsFromStrings(segment, sequence string) (start, lcd int64, offsets []int64, err error) {
+	es, err := lib.NewExecutionSegmentFromString(segment)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	ess, err := lib.NewExecutionSegmentSequenceFromString(sequence)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	tuple, err := lib.NewExecutionTuple(es, &ess)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	start, offsets, lcd = tuple.GetStripedOffsets()
+	return start, lcd, offsets, nil
+}
+
+// Custom returns a new SegmentedIndex with the given parameters instead of ones derived from an
+// execution segment. The parameters are checked as in NewSegmentedIndexChecked. An optional
+// lockMode of "rw" or "mutex" sets how the index is locked, see LockMode.
+func (m *Module) Custom(
+	ctx context.Context, start, lcd int64, offsets []int64, lockMode ...string,
+) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	if len(lockMode) > 1 {
+		return nil, fmt.Errorf("custom takes at most one lockMode but got %d", len(lockMode))
+	}
+	index, err := NewSegmentedIndexChecked(start, lcd, offsets)
+	if err != nil {
+		return nil, err
+	}
+	if len(lockMode) == 1 {
+		mode, err := ParseLockMode(lockMode[0])
+		if err != nil {
+			return nil, err
+		}
+		index.mx.exclusive = mode == LockExclusive
 	}
 
-	return m.shared.get(state, name)
+	return toJSIndex(ctx, withDefaultMax(index, m.opts.DefaultMax))
+}
+
+// DeleteShared removes the shared index with the given name and its cursors, returning whether it
+// existed. Indexes and cursors already returned keep working, but new calls with the same name get
+// a new index and new cursors.
+func (m *Module) DeleteShared(name string) bool {
+	index := m.shared.delete(name)
+	if index == nil {
+		return false
+	}
+	m.cursors.delete(index)
+	return true
+}
+
+// SharedNames returns the sorted names of all the shared indexes.
+func (m *Module) SharedNames() []string {
+	return m.shared.names()
+}
+
+// SharedCount returns the number of shared indexes.
+func (m *Module) SharedCount() int {
+	return m.shared.data.len()
+}
+
+// Snapshot returns the current position of each shared index by its name.
+// Each position is consistent, but they are not all taken at the same time.
+func (m *Module) Snapshot() map[string]SegmentedIndexResult {
+	return m.shared.snapshot()
+}
+
+// TotalScaled returns the sum of the scaled indexes of all the shared indexes, which is how many
+// times Next was called on them in total. As with Snapshot the scaled indexes aren't all read at
+// the same time, so it's only a best-effort sum while they are being moved.
+func (m *Module) TotalScaled() int64 {
+	return m.shared.totalScaled()
+}
+
+// ResetAll resets all the shared indexes. Ones created while it runs are already reset.
+func (m *Module) ResetAll() {
+	m.shared.resetAll()
+}
+
+// sharedConfig is the configuration of a shared index for RegisterAll.
+type sharedConfig struct {
+	// Start, LCD and Offsets replace the parameters derived from the execution segment if
+	// Offsets are set, and are checked as in NewSegmentedIndexChecked.
+	Start   int64   `js:"start"`
+	LCD     int64   `js:"lcd"`
+	Offsets []int64 `js:"offsets"`
+	// GoTo is the value the index is moved to as with GoTo if set.
+	GoTo *int64 `js:"goTo"`
+}
+
+// RegisterAll creates the shared index for each name in configs that doesn't exist yet,
+// configured as in its sharedConfig, and returns how many were created. Indexes that already
+// exist aren't changed. Invalid configs don't stop the others from being registered, but all
+// their errors are returned together.
+func (m *Module) RegisterAll(ctx context.Context, configs map[string]sharedConfig) (int, error) {
+	state, err := getState(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names) // so the errors are always in the same order
+
+	var created int
+	var errs []string
+	for _, name := range names {
+		config := configs[name]
+		if len(name) == 0 {
+			errs = append(errs, "empty name provided to registerAll")
+			continue
+		}
+		if len(config.Offsets) > 0 {
+			if err = checkParameters(config.LCD, config.Offsets); err != nil {
+				errs = append(errs, fmt.Sprintf("invalid config for %q: %s", name, err))
+				continue
+			}
+		}
+		_, ok, err := m.shared.getOrCreate(state, name, nil, func(index *SegmentedIndex) {
+			if len(config.Offsets) > 0 {
+				_, _ = index.Reconfigure(config.Start, config.LCD, config.Offsets) // already checked
+			}
+			if config.GoTo != nil {
+				index.GoTo(*config.GoTo)
+			}
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("couldn't register %q: %s", name, err))
+			continue
+		}
+		if ok {
+			created++
+		}
+	}
+	if len(errs) > 0 {
+		return created, errors.New(strings.Join(errs, "; "))
+	}
+	return created, nil
 }
 
 // NewSegmentedIndex returns a pointer to a new SegmentedIndex instance,
 // given a starting index, LCD and offsets as returned by GetStripedOffsets().
 func NewSegmentedIndex(start, lcd int64, offsets []int64) *SegmentedIndex {
-	return &SegmentedIndex{start: start, lcd: lcd, offsets: offsets}
+	index := &SegmentedIndex{start: start, lcd: lcd, offsets: offsets, prefixSums: prefixSums(offsets)}
+	index.updateFastNext()
+	return index
+}
+
+// NewSegmentedIndexWithLockMode is like NewSegmentedIndex but the index is locked as mode says.
+func NewSegmentedIndexWithLockMode(start, lcd int64, offsets []int64, mode LockMode) *SegmentedIndex {
+	index := NewSegmentedIndex(start, lcd, offsets)
+	index.mx.exclusive = mode == LockExclusive
+	return index
+}
+
+// NewSegmentedIndexChecked is like NewSegmentedIndex but returns an error if the parameters
+// are not valid, as otherwise the SegmentedIndex will return wrong results.
+func NewSegmentedIndexChecked(start, lcd int64, offsets []int64) (*SegmentedIndex, error) {
+	if err := checkParameters(lcd, offsets); err != nil {
+		return nil, err
+	}
+	return NewSegmentedIndex(start, lcd, offsets), nil
+}
+
+// checkParameters checks that there are offsets, that they are all positive and that they sum
+// up to a positive lcd.
+func checkParameters(lcd int64, offsets []int64) error {
+	if lcd <= 0 {
+		return fmt.Errorf("lcd must be positive but is %d", lcd)
+	}
+	if len(offsets) == 0 {
+		return errors.New("there must be at least one offset")
+	}
+	var sum int64
+	for i, offset := range offsets {
+		if offset <= 0 {
+			return fmt.Errorf("offsets must be positive but offset %d is %d", i, offset)
+		}
+		sum += offset
+	}
+	if sum != lcd {
+		return fmt.Errorf("the offsets sum up to %d instead of the lcd %d", sum, lcd)
+	}
+	return nil
 }
 
-// Next goes to the next scaled index and moves the unscaled one accordingly.
+// prefixSums returns a slice with one more element than offsets where each element is the sum
+// of all the offsets before it.
+func prefixSums(offsets []int64) []int64 {
+	sums := make([]int64, len(offsets)+1)
+	for i, offset := range offsets {
+		sums[i+1] = sums[i] + offset
+	}
+	return sums
+}
+
+// Next goes to the next scaled index and moves the unscaled one accordingly. If the unscaled
+// index would overflow int64, or there are no offsets so there is nothing to go through, the
+// index isn't moved and Done is set instead.
 func (s *SegmentedIndex) Next() SegmentedIndexResult {
+	if atomic.LoadInt32(&s.fastNext) == 0 {
+		return s.lockedNext()
+	}
+	s.mx.RLock()
+	// it's checked again as it could've changed since fastNext was read
+	if len(s.offsets) == 1 && !s.bounded && !s.frozen {
+		// With only one offset the unscaled index depends only on the scaled one, so concurrent
+		// calls only need to atomically increment scaled and don't need the write lock.
+		scaled := atomic.AddInt64(&s.scaled, 1)
+		if scaled-1 > (math.MaxInt64-s.start-1)/s.offsets[0] {
+			// the unscaled index would overflow, undo and let the locked path handle it
+			atomic.AddInt64(&s.scaled, -1)
+			s.mx.RUnlock()
+			return s.lockedNext()
+		}
+		unscaled := s.start + 1 + (scaled-1)*s.offsets[0]
+		// concurrent calls can finish in any order so only move unscaled forward
+		for {
+			old := atomic.LoadInt64(&s.unscaled)
+			if old >= unscaled || atomic.CompareAndSwapInt64(&s.unscaled, old, unscaled) {
+				break
+			}
+		}
+		s.signal()
+		result := SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled, Step: s.step(scaled - 1)}
+		onAdvance := s.onAdvance
+		s.mx.RUnlock()
+		notifyAdvance(onAdvance, result)
+		return result
+	}
+	s.mx.RUnlock()
+
+	return s.lockedNext()
+}
+
+// updateFastNext records whether Next can take its fast path, so that Next doesn't need to read
+// lock the index only to find out it can't. It must be called each time the offsets, the bound or
+// frozen change, with s.mx locked if the index is already in use.
+func (s *SegmentedIndex) updateFastNext() {
+	var fast int32
+	if len(s.offsets) == 1 && !s.bounded && !s.frozen {
+		fast = 1
+	}
+	atomic.StoreInt32(&s.fastNext, fast)
+}
+
+func (s *SegmentedIndex) lockedNext() SegmentedIndexResult {
 	s.mx.Lock()
-	defer s.mx.Unlock()
-	if s.scaled == 0 { // the 1 element(VU) is at the start
-		s.unscaled += s.start + 1 // the first element of the start 0, but the here we need it to be 1 so we add 1
-	} else { // if we are not at the first element we need to go through the offsets, looping over them
-		s.unscaled += s.offsets[int(s.scaled-1)%len(s.offsets)] // slice's index start at 0 ours start at 1
+	result, onAdvance := s.next(), s.onAdvance
+	s.mx.Unlock()
+	notifyAdvance(onAdvance, result)
+	return result
+}
+
+// SetOnAdvance sets fn to be called each time index is moved, replacing any function set before,
+// while nil removes it. Methods moving it as Next or Prev do, including NextN, ForEach and Claim,
+// call it once with each result they return that isn't Done. The others, like GoTo, Advance or
+// Reset, jump to the new position, so they call it once with it, without Step, if it's not where
+// it was before, even if they return Done or an error. fn is called after the index is unlocked,
+// so it can use the index, but as concurrent calls can then call it in any order it may not see
+// the results in order.
+// It's not a method so that JS can't set it, as a JS function set on an index other VUs use would
+// be called from their goroutines.
+func SetOnAdvance(index *SegmentedIndex, fn func(SegmentedIndexResult)) {
+	index.mx.Lock()
+	defer index.mx.Unlock()
+	index.onAdvance = fn
+}
+
+// notifyAdvance calls onAdvance, as read from the index while it was locked, with result if it's
+// set and the index was moved. It must be called with s.mx unlocked.
+func notifyAdvance(onAdvance func(SegmentedIndexResult), result SegmentedIndexResult) {
+	if onAdvance != nil && !result.Done {
+		onAdvance(result)
+	}
+}
+
+// unlockAndNotify unlocks s.mx and then calls notifyAdvance with each of results and the function
+// set by SetOnAdvance as it was while the index was locked. It must be called with s.mx locked.
+func (s *SegmentedIndex) unlockAndNotify(results ...SegmentedIndexResult) {
+	onAdvance := s.onAdvance
+	s.mx.Unlock()
+	for _, result := range results {
+		notifyAdvance(onAdvance, result)
+	}
+}
+
+// unlockAndNotifyJump does what unlockAndNotify does with the current position if the index was
+// moved away from scaled and unscaled, for the methods jumping to a new position. As its arguments
+// are evaluated at once, they can call it with defer right after locking s.mx.
+func (s *SegmentedIndex) unlockAndNotifyJump(scaled, unscaled int64) {
+	if s.scaled == scaled && s.unscaled == unscaled {
+		s.mx.Unlock()
+		return
 	}
+	s.unlockAndNotify(SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled})
+}
+
+// TryNextResult is the result of TryNext.
+type TryNextResult struct {
+	Result SegmentedIndexResult `js:"result"`
+	// OK is false if the index was locked by another call, in which case it wasn't advanced.
+	// It doesn't mean the index is exhausted, which is reported by Done in Result as for Next.
+	OK bool `js:"ok"`
+}
+
+// TryNext does what Next does unless the index is currently locked, in which case it returns
+// immediately without advancing the index and with OK set to false.
+func (s *SegmentedIndex) TryNext() TryNextResult {
+	if !s.mx.TryLock() {
+		return TryNextResult{}
+	}
+	result := s.next()
+	s.unlockAndNotify(result)
+	return TryNextResult{Result: result, OK: true}
+}
+
+// CompareAndNextResult is the result of CompareAndNext.
+type CompareAndNextResult struct {
+	Result SegmentedIndexResult `js:"result"`
+	// OK is false if the scaled index wasn't the expected one, in which case it wasn't advanced
+	// and Result is the current position. As for TryNext, Done in Result reports exhaustion.
+	OK bool `js:"ok"`
+}
+
+// CompareAndNext does what Next does but only if the current scaled index is expectedScaled,
+// so that callers racing for the same index can retry from the current position if they lose.
+func (s *SegmentedIndex) CompareAndNext(expectedScaled int64) CompareAndNextResult {
+	s.mx.Lock()
+	if s.scaled != expectedScaled {
+		result := SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+		s.mx.Unlock()
+		return CompareAndNextResult{Result: result}
+	}
+	result := s.next()
+	s.unlockAndNotify(result)
+	return CompareAndNextResult{Result: result, OK: true}
+}
+
+// next does what Next does but must be called with s.mx locked.
+func (s *SegmentedIndex) next() SegmentedIndexResult {
+	step, ok := s.nextStep(s.scaled, s.unscaled)
+	if !ok {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: true}
+	}
+	s.unscaled += step
 	s.scaled++
-	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+	s.signal()
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Step: step}
 }
 
-// Prev goes to the previous scaled value and sets the unscaled one accordingly.
-// Calling Prev when s.scaled == 0 is undefined.
-func (s *SegmentedIndex) Prev() SegmentedIndexResult {
+// nextUpTo does what next does but also doesn't go over unscaledMax.
+// It must be called with s.mx locked.
+func (s *SegmentedIndex) nextUpTo(unscaledMax int64) SegmentedIndexResult {
+	if step, ok := s.nextStep(s.scaled, s.unscaled); ok && s.unscaled+step > unscaledMax {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: true}
+	}
+	return s.next()
+}
+
+// ForEach calls Next and then fn with the result until the unscaled index would go over
+// unscaledMax or the bound of the index. If fn returns an error the iteration stops and it's
+// returned. What fn returns otherwise is ignored, so that any JS function can be used. The index
+// isn't locked while fn is called.
+func (s *SegmentedIndex) ForEach(unscaledMax int64, fn func(scaled, unscaled int64) (goja.Value, error)) error {
+	if fn == nil {
+		return errors.New("no function provided to forEach")
+	}
+	for {
+		s.mx.Lock()
+		result := s.nextUpTo(unscaledMax)
+		s.unlockAndNotify(result)
+		if result.Done {
+			return nil
+		}
+		if _, err := fn(result.Scaled, result.Unscaled); err != nil {
+			return err
+		}
+	}
+}
+
+// NextUntil calls Next and then fn with the result until fn returns true and returns that
+// result. If Next returns Done it is returned without calling fn. The optional maxIterations
+// caps how many times Next is called: if fn hasn't returned true after that many calls an error
+// is returned and the index is left where the last call moved it. Without it, or if it is not
+// positive, there is no cap. The index isn't locked while fn is called.
+func (s *SegmentedIndex) NextUntil(
+	fn func(scaled, unscaled int64) (bool, error), maxIterations ...int64,
+) (SegmentedIndexResult, error) {
+	if fn == nil {
+		return SegmentedIndexResult{}, errors.New("no function provided to nextUntil")
+	}
+	var limit int64
+	if len(maxIterations) > 0 {
+		limit = maxIterations[0]
+	}
+	for i := int64(1); ; i++ {
+		result := s.Next()
+		if result.Done {
+			return result, nil
+		}
+		ok, err := fn(result.Scaled, result.Unscaled)
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			return result, nil
+		}
+		if limit > 0 && i >= limit {
+			return result, fmt.Errorf("nextUntil didn't match after %d iterations", limit)
+		}
+	}
+}
+
+// SetMax bounds the index so that Next will not go over unscaledMax and will instead return
+// the current position with Done set. A negative unscaledMax removes the bound.
+func (s *SegmentedIndex) SetMax(unscaledMax int64) {
 	s.mx.Lock()
 	defer s.mx.Unlock()
+	s.max, s.bounded = unscaledMax, unscaledMax >= 0
+	s.updateFastNext()
+	s.signal()
+}
+
+// NextInto does what Next does but writes the result into result instead of returning it, so
+// that Go callers calling it in a loop can reuse the same result. From JS the argument is
+// converted to a new SegmentedIndexResult, so Next should be used there instead.
+func (s *SegmentedIndex) NextInto(result *SegmentedIndexResult) {
+	if result == nil {
+		s.Next()
+		return
+	}
+	*result = s.Next()
+}
+
+// NextN calls Next count times under a single lock and returns all the results in order.
+// A count that is not positive returns an empty slice and doesn't move the index.
+// If the index is bounded, fewer than count results are returned once the bound is reached.
+// As with PeekN, it returns an error and doesn't move the index if more than maxOwnedIndices
+// results would be returned, so that the index isn't locked for that long.
+func (s *SegmentedIndex) NextN(count int64) ([]SegmentedIndexResult, error) {
+	if count <= 0 {
+		return []SegmentedIndexResult{}, nil
+	}
+	s.mx.Lock()
+	size := s.reachable(s.scaled, count)
+	if size > maxOwnedIndices {
+		s.mx.Unlock()
+		return nil, fmt.Errorf("nextN would return %d results which is more than the limit of %d",
+			size, maxOwnedIndices)
+	}
+	results := make([]SegmentedIndexResult, 0, size)
+	for int64(len(results)) < size {
+		result := s.next()
+		if result.Done {
+			break
+		}
+		results = append(results, result)
+	}
+	s.unlockAndNotify(results...)
+	return results, nil
+}
+
+// reachable returns how many of count calls to Next from scaled can move the index before it
+// reaches its bound. It must be called with s.mx locked.
+func (s *SegmentedIndex) reachable(scaled, count int64) int64 {
+	if !s.bounded {
+		return count
+	}
+	maxScaled, _ := s.goToPosition(s.max)
+	if left := maxScaled - scaled; left < count {
+		if left < 0 {
+			return 0
+		}
+		return left
+	}
+	return count
+}
+
+// Peek returns what Next would return without moving either index.
+func (s *SegmentedIndex) Peek() SegmentedIndexResult {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	step, ok := s.nextStep(scaled, unscaled)
+	if !ok {
+		return SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled, Done: true}
+	}
+	return SegmentedIndexResult{Scaled: scaled + 1, Unscaled: unscaled + step, Step: step}
+}
+
+// PeekN returns the unscaled indexes the next count calls to Next would return without moving
+// the index. As with NextN, fewer are returned if the index is bounded and the bound is reached.
+// As with OwnedIndices, it returns an error instead if more than maxOwnedIndices would be returned.
+func (s *SegmentedIndex) PeekN(count int64) ([]int64, error) {
+	if count <= 0 {
+		return []int64{}, nil
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	size := s.reachable(scaled, count)
+	if size > maxOwnedIndices {
+		return nil, fmt.Errorf("peekN would return %d indexes which is more than the limit of %d",
+			size, maxOwnedIndices)
+	}
+	result := make([]int64, 0, size)
+	for int64(len(result)) < count {
+		step, ok := s.nextStep(scaled, unscaled)
+		if !ok {
+			break
+		}
+		scaled, unscaled = scaled+1, unscaled+step
+		result = append(result, unscaled)
+	}
+	return result, nil
+}
+
+// nextStep returns the step from the given position and whether Next can take it, which it can't
+// if there are no offsets as the index owns no unscaled indexes, if the index is frozen, if the
+// step would overflow or if it would go over the bound.
+func (s *SegmentedIndex) nextStep(scaled, unscaled int64) (int64, bool) {
+	if len(s.offsets) == 0 || s.frozen {
+		return 0, false
+	}
+	step := s.step(scaled)
+	return step, !overflows(unscaled, step) && !(s.bounded && unscaled+step > s.max)
+}
+
+// overflows returns whether adding step to unscaled overflows int64.
+func overflows(unscaled, step int64) bool {
+	return step > math.MaxInt64-unscaled
+}
+
+// step returns how much the unscaled index needs to move to go from scaled to the next scaled index.
+func (s *SegmentedIndex) step(scaled int64) int64 {
+	if scaled == 0 { // the 1 element(VU) is at the start
+		return s.start + 1 // the first element of the start 0, but the here we need it to be 1 so we add 1
+	}
+	// if we are not at the first element we need to go through the offsets, looping over them
+	return s.offsets[int(scaled-1)%len(s.offsets)] // slice's index start at 0 ours start at 1
+}
+
+// position returns the current scaled and unscaled index. It must be used instead of reading
+// them directly when only s.mx.RLock is held as Next might be changing them concurrently.
+func (s *SegmentedIndex) position() (scaled, unscaled int64) {
+	scaled = atomic.LoadInt64(&s.scaled)
+	if len(s.offsets) == 1 && scaled > 0 {
+		// concurrent calls to Next might not have moved unscaled yet, but it can be calculated
+		return scaled, s.start + 1 + (scaled-1)*s.offsets[0]
+	}
+	return scaled, atomic.LoadInt64(&s.unscaled)
+}
+
+// SegmentedIndexCycle is where in the striping cycle a SegmentedIndex is.
+type SegmentedIndexCycle struct {
+	// CyclePosition is the unscaled index modulo the lcd.
+	CyclePosition int64 `js:"cyclePosition"`
+	// OffsetIndex is the index of the offset the next call to Next will add to the unscaled index
+	// or -1 if it's the first call to Next, in which case start is used.
+	OffsetIndex int64 `js:"offsetIndex"`
+}
+
+// CurrentCycle returns the position of the index in the striping cycle.
+func (s *SegmentedIndex) CurrentCycle() SegmentedIndexCycle {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	cycle := SegmentedIndexCycle{OffsetIndex: -1}
+	if s.lcd > 0 { // as in goToPosition
+		cycle.CyclePosition = unscaled % s.lcd
+	}
+	if scaled > 0 {
+		cycle.OffsetIndex = (scaled - 1) % int64(len(s.offsets))
+	}
+	return cycle
+}
+
+// AtCycleBoundary returns whether the last call to Next finished a whole striping cycle, which
+// is when the scaled index is a positive multiple of the number of offsets.
+func (s *SegmentedIndex) AtCycleBoundary() bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, _ := s.position()
+	return len(s.offsets) > 0 && scaled > 0 && scaled%int64(len(s.offsets)) == 0
+}
+
+// SegmentedIndexParameters are the parameters a SegmentedIndex was created with.
+type SegmentedIndexParameters struct {
+	Start   int64
+	LCD     int64 `js:"lcd"`
+	Offsets []int64
+}
+
+// Parameters returns the start, lcd and a copy of the offsets of the index.
+func (s *SegmentedIndex) Parameters() SegmentedIndexParameters {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	offsets := make([]int64, len(s.offsets))
+	copy(offsets, s.offsets)
+	return SegmentedIndexParameters{Start: s.start, LCD: s.lcd, Offsets: offsets}
+}
+
+// OffsetsFromStart returns how far from start each of the unscaled indexes of the first striping
+// cycle is, so the i-th one of every cycle is at start + cycle*lcd + OffsetsFromStart()[i]. It's
+// a copy of the prefix sums the index keeps for GoTo, moved by the 1 the unscaled indexes start
+// from.
+func (s *SegmentedIndex) OffsetsFromStart() []int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	fromStart := make([]int64, len(s.offsets))
+	for i := range fromStart {
+		fromStart[i] = 1 + s.prefixSums[i]
+	}
+	return fromStart
+}
+
+// CycleInfo is the length of the striping cycle and how many unscaled indexes in it are owned.
+type CycleInfo struct {
+	LCD      int64 `js:"lcd"`
+	PerCycle int   `js:"perCycle"`
+}
+
+// CycleInfo returns the lcd and the number of offsets of the index. PerCycle/LCD is the share of
+// all the unscaled indexes the index goes through.
+func (s *SegmentedIndex) CycleInfo() CycleInfo {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return CycleInfo{LCD: s.lcd, PerCycle: len(s.offsets)}
+}
+
+// String returns the parameters and position of the index for debugging.
+func (s *SegmentedIndex) String() string {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	return fmt.Sprintf("SegmentedIndex{start: %d, lcd: %d, offsets: %v, scaled: %d, unscaled: %d}",
+		s.start, s.lcd, s.offsets, scaled, unscaled)
+}
+
+// Clone returns a new independent SegmentedIndex with the same parameters and position.
+func (s *SegmentedIndex) Clone() *SegmentedIndex {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	offsets := make([]int64, len(s.offsets))
+	copy(offsets, s.offsets)
+	clone := NewSegmentedIndex(s.start, s.lcd, offsets)
+	clone.mx.exclusive = s.mx.exclusive
+	clone.scaled, clone.unscaled = s.position()
+	clone.max, clone.bounded = s.max, s.bounded
+	clone.updateFastNext()
+	return clone
+}
+
+// Prev goes to the previous scaled value and sets the unscaled one accordingly.
+// Calling Prev when s.scaled == 0 returns an error and doesn't change the index.
+func (s *SegmentedIndex) Prev() (SegmentedIndexResult, error) {
+	s.mx.Lock()
+	result, err := s.prev()
+	onAdvance := s.onAdvance
+	s.mx.Unlock()
+	if err == nil {
+		notifyAdvance(onAdvance, result)
+	}
+	return result, err
+}
+
+// prev does what Prev does but must be called with s.mx locked.
+func (s *SegmentedIndex) prev() (SegmentedIndexResult, error) {
+	if s.frozen {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, errFrozen
+	}
+	if s.scaled == 0 {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, errPrevAtStart
+	}
+	var step int64
 	if s.scaled == 1 { // we are the first need to go to the 0th element which means we need to remove the start
-		s.unscaled -= s.start + 1 // this could've been just settign to 0
+		step = s.start + 1 // this could've been just settign to 0
 	} else { // not at the first element - need to get the previously added offset so
-		s.unscaled -= s.offsets[int(s.scaled-2)%len(s.offsets)] // slice's index start 0 our start at 1
+		step = s.offsets[int(s.scaled-2)%len(s.offsets)] // slice's index start 0 our start at 1
 	}
+	s.unscaled -= step
 	s.scaled--
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Step: step}, nil
+}
+
+// Advance moves the index n scaled indexes forward as n calls to Next would, but in constant
+// time under a single lock. If Next can't go that far, because of the bound or as the unscaled
+// index would overflow, the index goes as far as it can and Done is set. A negative n moves it
+// backwards as Rewind(-n) would.
+func (s *SegmentedIndex) Advance(n int64) (SegmentedIndexResult, error) {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	return s.advance(n)
+}
+
+// Rewind moves the index n scaled indexes backwards as n calls to Prev would, but in constant
+// time under a single lock. If the start is reached before that the index stays at it and an
+// error is returned. A negative n moves it forward as Advance(-n) would.
+func (s *SegmentedIndex) Rewind(n int64) (SegmentedIndexResult, error) {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if n == math.MinInt64 { // so that -n doesn't overflow, as no index can go that far either way
+		n++
+	}
+	return s.advance(-n)
+}
+
+// advance does what Advance does but must be called with s.mx locked.
+func (s *SegmentedIndex) advance(n int64) (SegmentedIndexResult, error) {
+	if s.frozen {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, errFrozen
+	}
+	switch {
+	case n > 0:
+		if len(s.offsets) == 0 {
+			return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: true}, nil
+		}
+		if last := s.lastScaled(); last-s.scaled < n {
+			if last > s.scaled {
+				s.scaled, s.unscaled = last, s.unscaledAt(last)
+				s.signal()
+			}
+			return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: true}, nil
+		}
+		s.scaled += n
+		s.unscaled = s.unscaledAt(s.scaled)
+		s.signal()
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Step: s.step(s.scaled - 1)}, nil
+	case n < 0:
+		if s.scaled+n < 0 {
+			s.scaled, s.unscaled = 0, 0
+			s.signal()
+			return SegmentedIndexResult{}, errPrevAtStart
+		}
+		s.scaled += n
+		s.unscaled = s.unscaledAt(s.scaled)
+		s.signal()
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Step: s.step(s.scaled)}, nil
+	}
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, nil
+}
+
+// lastScaled returns the biggest scaled index Next can go to, which is limited by the bound and
+// by maxScaled. It must be called with s.mx locked.
+func (s *SegmentedIndex) lastScaled() int64 {
+	last := s.maxScaled()
+	if s.bounded {
+		if bounded, _ := s.goToPosition(s.max); bounded < last {
+			last = bounded
+		}
+	}
+	return last
+}
+
+// maxScaled returns the biggest scaled index whose unscaled index doesn't overflow int64.
+// It must be called with s.mx locked.
+func (s *SegmentedIndex) maxScaled() int64 {
+	scaled, _ := s.goToPosition(math.MaxInt64)
+	return scaled
+}
+
+// Reset sets both the scaled and unscaled index back to 0 as if Next was never called.
+func (s *SegmentedIndex) Reset() SegmentedIndexResult {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return s.frozenResult()
+	}
+	s.scaled, s.unscaled = 0, 0
+	s.signal()
 	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
 }
 
+// Freeze stops the index from being moved until Unfreeze is called, by any of its holders.
+// Methods moving it that can return an error return one, while the others, like Next and GoTo,
+// return the current position with Done set as Next does once it can't go further.
+func (s *SegmentedIndex) Freeze() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.frozen = true
+	s.updateFastNext()
+}
+
+// Unfreeze lets the index be moved again after Freeze.
+func (s *SegmentedIndex) Unfreeze() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.frozen = false
+	s.updateFastNext()
+	s.signal()
+}
+
+// frozenResult is what the methods moving the index return if it's frozen. It must be called
+// with s.mx locked.
+func (s *SegmentedIndex) frozenResult() SegmentedIndexResult {
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: true}
+}
+
+// Current returns the current scaled and unscaled index without changing them.
+func (s *SegmentedIndex) Current() SegmentedIndexResult {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	return SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled}
+}
+
+// GetScaled returns the current scaled index.
+func (s *SegmentedIndex) GetScaled() int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return atomic.LoadInt64(&s.scaled)
+}
+
+// GetUnscaled returns the current unscaled index.
+func (s *SegmentedIndex) GetUnscaled() int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, unscaled := s.position()
+	return unscaled
+}
+
+// SegmentedIndexResult is a position of a SegmentedIndex. In JS its fields are lowercase.
 type SegmentedIndexResult struct {
-	Scaled, Unscaled int64
+	Scaled   int64 `js:"scaled"`
+	Unscaled int64 `js:"unscaled"`
+	// Done is set by Next when the index is bounded and the next unscaled index would be over the
+	// bound, when it would overflow int64, when the index owns no unscaled indexes or when it's
+	// frozen, in which case Scaled and Unscaled are the current unchanged values.
+	Done bool `js:"done"`
+	// Step is how much Next, Peek or Prev moved the unscaled index, which is start+1 for the first
+	// index and one of the offsets otherwise. It's 0 if the index didn't move or was moved in
+	// another way.
+	Step int64 `js:"step"`
 }
 
 // GoTo sets the scaled index to its biggest value for which the corresponding
-// unscaled index is is smaller or equal to value.
-func (s *SegmentedIndex) GoTo(value int64) SegmentedIndexResult { // TODO optimize
+// unscaled index is is smaller or equal to value. As no unscaled index is smaller than 1,
+// any value below it, including negative ones, resets the index to 0.
+func (s *SegmentedIndex) GoTo(value int64) SegmentedIndexResult {
+	// the position is found with only the parameters read locked, so that calls to Next aren't
+	// blocked while it is
+	s.mx.RLock()
+	params := &SegmentedIndex{start: s.start, lcd: s.lcd, offsets: s.offsets, prefixSums: s.prefixSums}
+	s.mx.RUnlock()
+	scaled, unscaled := params.goToPosition(value)
+
 	s.mx.Lock()
-	defer s.mx.Unlock()
-	var gi int64
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return s.frozenResult()
+	}
+	if !s.sameParameters(params) { // they were changed in between, for example by Reconfigure
+		scaled, unscaled = s.goToPosition(value)
+	}
+	s.scaled, s.unscaled = scaled, unscaled
+	s.signal()
+	return SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled}
+}
+
+// sameParameters returns whether the index still has the parameters of other. As every change of
+// the offsets makes new prefix sums, it's enough to check they are the same slice.
+// It must be called with s.mx locked.
+func (s *SegmentedIndex) sameParameters(other *SegmentedIndex) bool {
+	return s.start == other.start && s.lcd == other.lcd && len(s.prefixSums) == len(other.prefixSums) &&
+		(len(s.prefixSums) == 0 || &s.prefixSums[0] == &other.prefixSums[0])
+}
+
+// GoToBatch does what GoTo does for each of values in turn under a single lock and returns all
+// the results in order, leaving the index at the last one. As each GoTo takes the same time
+// wherever the index is, values don't need to be sorted.
+func (s *SegmentedIndex) GoToBatch(values []int64) []SegmentedIndexResult {
+	results := make([]SegmentedIndexResult, len(values))
+	if len(values) == 0 {
+		return results
+	}
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		for i := range results {
+			results[i] = s.frozenResult()
+		}
+		return results
+	}
+	for i, value := range values {
+		scaled, unscaled := s.goToPosition(value)
+		results[i] = SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled}
+	}
+	last := results[len(results)-1]
+	s.scaled, s.unscaled = last.Scaled, last.Unscaled
+	s.signal()
+	return results
+}
+
+// GoToPrevOwned sets the scaled index to its biggest value for which the corresponding unscaled
+// index is smaller than value, so unlike GoTo it never goes to value itself. Any value below 2
+// resets the index to 0.
+func (s *SegmentedIndex) GoToPrevOwned(value int64) SegmentedIndexResult {
+	if value == math.MinInt64 {
+		value++ // so that value-1 doesn't overflow, as it's reset either way
+	}
+	return s.GoTo(value - 1) // as indexes are integers, smaller than value is smaller or equal than value-1
+}
+
+// GoToCeil sets the scaled index to its smallest value for which the corresponding unscaled
+// index is bigger or equal to value, so any value below 1 goes to the first unscaled index.
+// It does what GoTo does and then what Next does if the unscaled index is smaller than value or
+// there is none, so if Next can't go further the index stays where GoTo left it and Done is set.
+func (s *SegmentedIndex) GoToCeil(value int64) SegmentedIndexResult {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return s.frozenResult()
+	}
+	s.scaled, s.unscaled = s.goToPosition(value)
+	s.signal()
+	if s.scaled == 0 || s.unscaled < value {
+		return s.next()
+	}
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+}
+
+// GoToCountingResult is the result of GoToCounting.
+type GoToCountingResult struct {
+	SegmentedIndexResult `js:"-"`
+	// Skipped is how much the scaled index moved, which is negative if it moved backwards.
+	Skipped int64 `js:"skipped"`
+}
+
+// GoToCounting does what GoTo does but also returns how many scaled indexes were skipped.
+func (s *SegmentedIndex) GoToCounting(value int64) GoToCountingResult {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return GoToCountingResult{SegmentedIndexResult: s.frozenResult()}
+	}
+	previous := s.scaled
+	s.scaled, s.unscaled = s.goToPosition(value)
+	s.signal()
+	return GoToCountingResult{
+		SegmentedIndexResult: SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled},
+		Skipped:              s.scaled - previous,
+	}
+}
+
+// CycleBreakdown is how GoTo splits a value between whole striping cycles and the rest.
+type CycleBreakdown struct {
+	// WholeCycles is how many whole cycles there are up to the value.
+	WholeCycles int64 `js:"wholeCycles"`
+	// ScaledFromCycles is how many scaled indexes there are in those cycles.
+	ScaledFromCycles int64 `js:"scaledFromCycles"`
+	// RemainderPosition is the position of the value in the cycle after them.
+	RemainderPosition int64 `js:"remainderPosition"`
+}
+
+// CycleBreakdown returns how GoTo(value) splits value between whole cycles and the rest, without
+// changing the index. As GoTo does, it treats negative values as 0.
+func (s *SegmentedIndex) CycleBreakdown(value int64) CycleBreakdown {
+	if value < 0 {
+		value = 0
+	}
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if s.lcd <= 0 { // as in goToPosition
+		return CycleBreakdown{}
+	}
+	wholeCycles := value / s.lcd
+	return CycleBreakdown{
+		WholeCycles:       wholeCycles,
+		ScaledFromCycles:  wholeCycles * int64(len(s.offsets)),
+		RemainderPosition: value % s.lcd,
+	}
+}
+
+// goToPosition returns the scaled and unscaled index GoTo(value) would go to without changing
+// the index. The intermediate unscaled values can overflow for values close to math.MaxInt64,
+// but as they are moved back with the same wrapping arithmetic the result doesn't.
+func (s *SegmentedIndex) goToPosition(value int64) (scaled, unscaled int64) {
+	if value < 0 { // seeking before the start
+		return 0, 0
+	}
+	if s.lcd <= 0 || len(s.offsets) == 0 { // only an unchecked index can have them, and it owns nothing
+		return 0, 0
+	}
 	// Because of the cyclical nature of the striping algorithm (with a cycle
 	// length of LCD, the least common denominator), when scaling large values
 	// (i.e. many multiples of the LCD), we can quickly calculate how many times
@@ -152,29 +1422,402 @@ func (s *SegmentedIndex) GoTo(value int64) SegmentedIndexResult { // TODO optimi
 	wholeCycles := (value / s.lcd)
 	// So we can set some approximate initial values quickly, since we also know
 	// precisely how many scaled values there are per cycle length.
-	s.scaled = wholeCycles * int64(len(s.offsets))
-	s.unscaled = wholeCycles*s.lcd + s.start + 1 // our indexes are from 1 the start is from 0
-	// Approach the final value using the slow algorithm with the step by step loop
-	// TODO: this can be optimized by another array with size offsets that instead of the offsets
-	// from the previous is the offset from either 0 or start
-	i := s.start
-	for ; i < value%s.lcd; gi, i = gi+1, i+s.offsets[gi] {
-		s.scaled++
-		s.unscaled += s.offsets[gi]
-	}
+	scaled = wholeCycles * int64(len(s.offsets))
+	unscaled = wholeCycles*s.lcd + s.start + 1 // our indexes are from 1 the start is from 0
+	// Approach the final value by finding how many of the offsets from start are still before it,
+	// which as the prefix sums are increasing can be binary searched.
+	remainder := value % s.lcd
+	gi := int64(sort.Search(len(s.prefixSums), func(i int) bool {
+		return s.start+s.prefixSums[i] >= remainder
+	}))
+	scaled += gi
+	unscaled += s.prefixSums[gi]
 
 	if gi > 0 { // there were more values after the wholecycles
 		// the last offset actually shouldn't have been added
-		s.unscaled -= s.offsets[gi-1]
-	} else if s.scaled > 0 { // we didn't actually have more values after the wholecycles but we still had some
+		unscaled -= s.offsets[gi-1]
+	} else if scaled > 0 { // we didn't actually have more values after the wholecycles but we still had some
 		// in this case the unscaled value needs to move back by the last offset as it would've been
 		// the one to get it from the value it needs to be to it's current one
-		s.unscaled -= s.offsets[len(s.offsets)-1]
+		unscaled -= s.offsets[len(s.offsets)-1]
 	}
 
-	if s.scaled == 0 {
-		s.unscaled = 0 // we would've added the start and 1
+	if scaled == 0 {
+		unscaled = 0 // we would've added the start and 1
 	}
 
-	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+	return scaled, unscaled
+}
+
+// GoToScaled sets the scaled index to scaledTarget and the unscaled index to the one
+// corresponding to it. A negative scaledTarget, or any if there are no offsets, resets the
+// index to 0. If the unscaled index would overflow int64, the index goes to the biggest scaled
+// index for which it doesn't and Done is set.
+func (s *SegmentedIndex) GoToScaled(scaledTarget int64) SegmentedIndexResult {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	return s.goToScaled(scaledTarget)
+}
+
+// goToScaled is GoToScaled with s.mx already locked.
+func (s *SegmentedIndex) goToScaled(scaledTarget int64) SegmentedIndexResult {
+	if s.frozen {
+		return s.frozenResult()
+	}
+	if scaledTarget < 0 || len(s.offsets) == 0 { // without offsets there are no scaled indexes
+		scaledTarget = 0
+	}
+	var done bool
+	if last := s.maxScaled(); scaledTarget > last {
+		scaledTarget, done = last, true
+	}
+	s.scaled, s.unscaled = scaledTarget, s.unscaledAt(scaledTarget)
+	s.signal()
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled, Done: done}
+}
+
+// GoToFraction moves the index fraction of the way through the unscaled indexes from 1 to
+// datasetSize it owns, so that Next goes through the rest of them. It does what GoToScaled does
+// with fraction of what TotalOwned(datasetSize) returns, rounded down. It returns an error and
+// doesn't move the index if fraction isn't between 0 and 1.
+func (s *SegmentedIndex) GoToFraction(datasetSize int64, fraction float64) (SegmentedIndexResult, error) {
+	if !(fraction >= 0 && fraction <= 1) { // also catches NaN
+		return s.Current(), fmt.Errorf("fraction must be between 0 and 1 but is %v", fraction)
+	}
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, errFrozen
+	}
+	total, _ := s.goToPosition(datasetSize)
+	scaled := int64(fraction * float64(total))
+	if scaled > total { // float64 can't represent every big total exactly
+		scaled = total
+	}
+	return s.goToScaled(scaled), nil
+}
+
+// RewindToCycleStart moves the index back to the start of the striping cycle it's in, so that
+// Next goes through the indexes of the cycle again. At a cycle boundary, it doesn't move.
+func (s *SegmentedIndex) RewindToCycleStart() SegmentedIndexResult {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	var cycleStart int64
+	if len(s.offsets) > 0 {
+		cycleStart = s.scaled - s.scaled%int64(len(s.offsets))
+	}
+	return s.goToScaled(cycleStart)
+}
+
+// UnscaledAt returns the unscaled index corresponding to the given scaled one without moving
+// the index. It returns 0 for scaled indexes that aren't positive, if there are no offsets or if
+// the unscaled index would overflow int64.
+func (s *SegmentedIndex) UnscaledAt(scaled int64) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if scaled > s.maxScaled() {
+		return 0
+	}
+	return s.unscaledAt(scaled)
+}
+
+// unscaledAt returns the unscaled index corresponding to the given scaled one, which must not be
+// over maxScaled as the unscaled index would then overflow.
+func (s *SegmentedIndex) unscaledAt(scaled int64) int64 {
+	if scaled <= 0 || len(s.offsets) == 0 {
+		return 0
+	}
+	// the first scaled index is at start + 1 and each len(offsets) after it are one lcd further,
+	// with the ones in between being the offsets from it
+	wholeCycles := (scaled - 1) / int64(len(s.offsets))
+	return s.start + 1 + wholeCycles*s.lcd + s.prefixSums[(scaled-1)%int64(len(s.offsets))]
+}
+
+// CheckConsistency returns an error describing how the position of the index is wrong if the
+// unscaled index isn't the one the scaled index corresponds to, which can only happen if the index
+// was misused or has a bug. It locks the index exclusively, as position would hide a wrong
+// unscaled index with one offset.
+func (s *SegmentedIndex) CheckConsistency() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.checkPosition(s.scaled, s.unscaled)
+}
+
+// checkPosition returns an error describing why the index can't be at scaled and unscaled, as
+// Next never goes to them. It must be called with s.mx locked.
+func (s *SegmentedIndex) checkPosition(scaled, unscaled int64) error {
+	if scaled < 0 {
+		return fmt.Errorf("the scaled index %d is negative", scaled)
+	}
+	if len(s.offsets) == 0 && scaled != 0 {
+		return fmt.Errorf("the scaled index is %d but there are no offsets", scaled)
+	}
+	if last := s.lastScaled(); scaled > last {
+		return fmt.Errorf("the scaled index %d is past the last one Next can go to, %d", scaled, last)
+	}
+	if expected := s.unscaledAt(scaled); unscaled != expected {
+		return fmt.Errorf("the unscaled index is %d but should be %d for the scaled index %d",
+			unscaled, expected, scaled)
+	}
+	return nil
+}
+
+// Contains returns whether unscaled is one of the unscaled indexes Next goes through.
+func (s *SegmentedIndex) Contains(unscaled int64) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, ok := s.scaledOf(unscaled)
+	return ok
+}
+
+// ScaledOf returns the scaled index for which Next returns unscaled and whether there is one,
+// as it's the inverse of UnscaledAt for the unscaled indexes Next goes through. In JS both are
+// returned in an array.
+func (s *SegmentedIndex) ScaledOf(unscaled int64) (int64, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.scaledOf(unscaled)
+}
+
+// scaledOf does what ScaledOf does but must be called with s.mx locked.
+func (s *SegmentedIndex) scaledOf(unscaled int64) (int64, bool) {
+	distance := unscaled - s.start - 1 // from the first unscaled index
+	if distance < 0 || s.lcd <= 0 {
+		return 0, false
+	}
+	// the indexes in each cycle are the same offsets from its start
+	inCycle := distance % s.lcd
+	i := sort.Search(len(s.offsets), func(i int) bool { return s.prefixSums[i] >= inCycle })
+	if i == len(s.offsets) || s.prefixSums[i] != inCycle {
+		return 0, false
+	}
+	return distance/s.lcd*int64(len(s.offsets)) + int64(i) + 1, true
+}
+
+// OwnsKey returns whether the index owns key out of space keys. The key is mapped to an
+// unscaled index from 1 to space by the 64-bit FNV-1a hash of it modulo space plus one, so the
+// same key is always mapped to the same unscaled index. It returns false if space isn't positive.
+func (s *SegmentedIndex) OwnsKey(key string, space int64) bool {
+	if space <= 0 {
+		return false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return s.Contains(int64(h.Sum64()%uint64(space)) + 1)
+}
+
+// TotalOwned returns how many of the unscaled indexes from 1 to datasetSize Next goes through.
+func (s *SegmentedIndex) TotalOwned(datasetSize int64) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	// it's the scaled index of the biggest owned unscaled index up to datasetSize
+	scaled, _ := s.goToPosition(datasetSize)
+	return scaled
+}
+
+// FirstOwned returns the first unscaled index Next goes through from scaled index 0, or 0 if the
+// index owns no unscaled indexes.
+func (s *SegmentedIndex) FirstOwned() int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if len(s.offsets) == 0 {
+		return 0
+	}
+	return s.unscaledAt(1)
+}
+
+// LastOwned returns the biggest unscaled index up to unscaledMax that Next goes through, or 0 if
+// there is none. Together with FirstOwned it brackets the unscaled indexes the segment is
+// responsible for.
+func (s *SegmentedIndex) LastOwned(unscaledMax int64) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	_, unscaled := s.goToPosition(unscaledMax)
+	return unscaled
+}
+
+// maxOwnedIndices is the most unscaled indexes OwnedIndices will return.
+const maxOwnedIndices = 10_000_000
+
+// OwnedIndices returns all the unscaled indexes from 1 to unscaledMax that Next goes through
+// from scaled index 0, without moving the index. It returns an error instead if there are more
+// than maxOwnedIndices of them.
+func (s *SegmentedIndex) OwnedIndices(unscaledMax int64) ([]int64, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	count, _ := s.goToPosition(unscaledMax)
+	if count > maxOwnedIndices {
+		return nil, fmt.Errorf("ownedIndices would return %d indexes which is more than the limit of %d",
+			count, maxOwnedIndices)
+	}
+	result := make([]int64, count)
+	var unscaled int64
+	for scaled := int64(0); scaled < count; scaled++ {
+		unscaled += s.step(scaled)
+		result[scaled] = unscaled
+	}
+	return result, nil
+}
+
+// OwnedChan returns a channel on which the unscaled indexes OwnedIndices(unscaledMax) would
+// return are sent one by one from a new goroutine, without moving the index and without a limit
+// on how many there are. The channel is closed after the last one or once ctx is done.
+func (s *SegmentedIndex) OwnedChan(ctx context.Context, unscaledMax int64) <-chan int64 {
+	s.mx.RLock()
+	count, _ := s.goToPosition(unscaledMax)
+	walker := NewSegmentedIndex(s.start, s.lcd, s.offsets) // so that Reconfigure doesn't affect it
+	s.mx.RUnlock()
+
+	ch := make(chan int64)
+	go func() {
+		defer close(ch)
+		var unscaled int64
+		for scaled := int64(0); scaled < count; scaled++ {
+			unscaled += walker.step(scaled)
+			select {
+			case ch <- unscaled:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// SegmentedIndexChunk is a range of consecutive unscaled indexes from Start to End, without End.
+type SegmentedIndexChunk struct {
+	Start int64 `js:"start"`
+	End   int64 `js:"end"`
+}
+
+// Chunks returns the unscaled indexes OwnedIndices(unscaledMax) would return as ranges of
+// consecutive ones, without moving the index. As it goes through all of them it returns an error
+// instead if there are more than maxOwnedIndices of them.
+func (s *SegmentedIndex) Chunks(unscaledMax int64) ([]SegmentedIndexChunk, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	count, _ := s.goToPosition(unscaledMax)
+	if count > maxOwnedIndices {
+		return nil, fmt.Errorf("chunks would go through %d indexes which is more than the limit of %d",
+			count, maxOwnedIndices)
+	}
+	chunks := []SegmentedIndexChunk{}
+	var unscaled int64
+	for scaled := int64(0); scaled < count; scaled++ {
+		unscaled += s.step(scaled)
+		if last := len(chunks) - 1; last >= 0 && chunks[last].End == unscaled {
+			chunks[last].End++
+		} else {
+			chunks = append(chunks, SegmentedIndexChunk{Start: unscaled, End: unscaled + 1})
+		}
+	}
+	return chunks, nil
+}
+
+// Reconfigure replaces the parameters of the index while keeping its scaled index, so the
+// unscaled index jumps to the one the new parameters have for the same scaled index.
+// The parameters are checked as in NewSegmentedIndexChecked. It returns an error and doesn't change
+// the index if they are wrong or the new unscaled index would overflow int64.
+func (s *SegmentedIndex) Reconfigure(start, lcd int64, offsets []int64) (SegmentedIndexResult, error) {
+	if err := checkParameters(lcd, offsets); err != nil {
+		return s.Current(), err
+	}
+	params := &SegmentedIndex{start: start, lcd: lcd, offsets: offsets, prefixSums: prefixSums(offsets)}
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	result := SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+	if s.frozen {
+		return result, errFrozen
+	}
+	if s.scaled > params.maxScaled() {
+		return result, fmt.Errorf("the unscaled index for the scaled index %d overflows with the new parameters", s.scaled)
+	}
+	s.start, s.lcd, s.offsets, s.prefixSums = params.start, params.lcd, params.offsets, params.prefixSums
+	s.updateFastNext()
+	s.unscaled = s.unscaledAt(s.scaled)
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, nil
+}
+
+// RemapUnscaled replaces the start of the index with newBase while keeping its scaled index and
+// offsets, so the unscaled index moves by the difference between newBase and the old start. It's
+// meant for recovering after the unscaled indexes were laid out again, as anything that relied on
+// the old unscaled indexes will not see the same ones again. It returns an error and doesn't
+// change the index if newBase is negative or the unscaled index would overflow int64.
+func (s *SegmentedIndex) RemapUnscaled(newBase int64) (SegmentedIndexResult, error) {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	result := SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}
+	if s.frozen {
+		return result, errFrozen
+	}
+	if newBase < 0 {
+		return result, fmt.Errorf("the new base must not be negative but is %d", newBase)
+	}
+	delta := newBase - s.start
+	if s.scaled > 0 && overflows(s.unscaled, delta) {
+		return result, fmt.Errorf("remapping to base %d overflows the unscaled index %d", newBase, s.unscaled)
+	}
+	s.start = newBase
+	s.unscaled = s.unscaledAt(s.scaled)
+	s.signal()
+	return SegmentedIndexResult{Scaled: s.scaled, Unscaled: s.unscaled}, nil
+}
+
+// SetInitialScaled makes a new index start as if GoToScaled(k) was called, so that the first k
+// scaled indexes are skipped. It returns an error if the index has already been moved.
+func (s *SegmentedIndex) SetInitialScaled(k int64) error {
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return errFrozen
+	}
+	if s.scaled != 0 || s.initialScaled != 0 {
+		return errors.New("the initial scaled index can only be set before the index is moved")
+	}
+	if k < 0 {
+		return fmt.Errorf("the initial scaled index can't be negative but is %d", k)
+	}
+	if len(s.offsets) == 0 { // as in goToScaled, without offsets there are no scaled indexes
+		k = 0
+	}
+	if last := s.maxScaled(); k > last {
+		return fmt.Errorf("the initial scaled index %d is past %d, the last one whose unscaled index doesn't overflow",
+			k, last)
+	}
+	s.initialScaled = k
+	s.scaled, s.unscaled = k, s.unscaledAt(k)
+	return nil
+}
+
+func (s *SegmentedIndex) initialScaledValue() int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.initialScaled
+}
+
+// Remaining returns how many more times Next can be called before the unscaled index goes over
+// unscaledMax.
+func (s *SegmentedIndex) Remaining(unscaledMax int64) int64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, _ := s.position()
+	// all the scaled indexes up to the one GoTo would go to have unscaled indexes <= unscaledMax
+	maxScaled, _ := s.goToPosition(unscaledMax)
+	if maxScaled < scaled {
+		return 0
+	}
+	return maxScaled - scaled
+}
+
+// Progress returns the fraction of the unscaled indexes from 1 to datasetSize Next goes through
+// that it has already gone through, from 0 to 1. If there are none it returns 1.
+func (s *SegmentedIndex) Progress(datasetSize int64) float64 {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, _ := s.position()
+	total, _ := s.goToPosition(datasetSize)
+	if total == 0 || scaled >= total {
+		return 1
+	}
+	return float64(scaled) / float64(total)
 }
@@ -0,0 +1,1400 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib"
+)
+
+// testSequences are the sequences the tests build the indexes of every segment of.
+var testSequences = []string{
+	"0,1",
+	"0,1/2,1",
+	"0,1/3,2/3,1",
+	"0,1/4,1/2,3/4,1",
+	"0,1/10,3/10,6/10,1",
+}
+
+// sequenceIndexes returns a new index for each segment of sequence.
+func sequenceIndexes(t *testing.T, sequence string) []*SegmentedIndex {
+	t.Helper()
+	ess, err := lib.NewExecutionSegmentSequenceFromString(sequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := make([]*SegmentedIndex, len(ess))
+	for i, es := range ess {
+		tuple, err := lib.NewExecutionTuple(es, &ess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, offsets, lcd := tuple.GetStripedOffsets()
+		indexes[i] = NewSegmentedIndex(start, lcd, offsets)
+	}
+	return indexes
+}
+
+// randomSequence returns a sequence of up to 6 segments with random ends in twentieths.
+func randomSequence(r *rand.Rand) string {
+	points := []string{"0"}
+	for i := 1; i < 20; i++ {
+		if r.Intn(4) == 0 {
+			points = append(points, strconv.Itoa(i)+"/20")
+		}
+	}
+	return strings.Join(append(points, "1"), ",")
+}
+
+// naiveGoTo is GoTo as it was before the prefix sums, walking the offsets one by one.
+func naiveGoTo(s *SegmentedIndex, value int64) SegmentedIndexResult {
+	var gi int64
+	wholeCycles := (value / s.lcd)
+	scaled := wholeCycles * int64(len(s.offsets))
+	unscaled := wholeCycles*s.lcd + s.start + 1
+	for i := s.start; i < value%s.lcd; gi, i = gi+1, i+s.offsets[gi] {
+		scaled++
+		unscaled += s.offsets[gi]
+	}
+	if gi > 0 {
+		unscaled -= s.offsets[gi-1]
+	} else if scaled > 0 {
+		unscaled -= s.offsets[len(s.offsets)-1]
+	}
+	if scaled == 0 {
+		unscaled = 0
+	}
+	return SegmentedIndexResult{Scaled: scaled, Unscaled: unscaled}
+}
+
+func TestGoToMatchesNaive(t *testing.T) {
+	t.Parallel()
+	r := rand.New(rand.NewSource(1))
+	sequences := append([]string{}, testSequences...)
+	for i := 0; i < 50; i++ {
+		sequences = append(sequences, randomSequence(r))
+	}
+	for _, sequence := range sequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			for i := 0; i < 200; i++ {
+				value := r.Int63n(20 * index.lcd)
+				if i < 50 {
+					value = int64(i)
+				}
+				if got, want := index.GoTo(value), naiveGoTo(index, value); got != want {
+					t.Fatalf("GoTo(%d) on %s of %q returned %+v but the naive one %+v", value, index, sequence, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestNextN(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name  string
+		max   int64 // no bound if negative
+		count int64
+		want  []int64
+	}{
+		{name: "unbounded", max: -1, count: 4, want: []int64{1, 2, 4, 5}},
+		{name: "not positive", max: -1, count: 0, want: []int64{}},
+		{name: "up to the bound", max: 4, count: 4, want: []int64{1, 2, 4}},
+		{name: "huge count with a bound", max: 4, count: 1 << 62, want: []int64{1, 2, 4}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 3, []int64{1, 2})
+			index.SetMax(tc.max)
+			results, err := index.NextN(tc.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tc.want) {
+				t.Fatalf("expected %d results but got %v", len(tc.want), results)
+			}
+			for i, result := range results {
+				if result.Scaled != int64(i+1) || result.Unscaled != tc.want[i] {
+					t.Fatalf("expected %v but got %v", tc.want, results)
+				}
+			}
+		})
+	}
+}
+
+func TestNextNLimit(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	if _, err := index.NextN(maxOwnedIndices + 1); err == nil {
+		t.Fatal("expected an error for more than maxOwnedIndices results")
+	}
+	if current := index.Current(); current != (SegmentedIndexResult{}) {
+		t.Fatalf("expected the index not to move but it's at %+v", current)
+	}
+	if results, err := index.NextN(maxOwnedIndices); err != nil || int64(len(results)) != maxOwnedIndices {
+		t.Fatalf("expected maxOwnedIndices results but got %d, %v", len(results), err)
+	}
+}
+
+func TestPeekNAgreesWithNextN(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			for _, max := range []int64{-1, 0, 7, 30} {
+				index.Reset()
+				index.SetMax(max)
+				index.NextN(3)
+				peeked, err := index.PeekN(10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				results, err := index.NextN(10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(peeked) != len(results) {
+					t.Fatalf("peeked %v but got %v from %s", peeked, results, index)
+				}
+				for i, result := range results {
+					if peeked[i] != result.Unscaled {
+						t.Fatalf("peeked %v but got %v from %s", peeked, results, index)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPeekNLimit(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	if _, err := index.PeekN(maxOwnedIndices + 1); err == nil {
+		t.Fatal("expected an error for more than the limit on an unbounded index")
+	}
+	index.SetMax(4)
+	peeked, err := index.PeekN(1 << 62)
+	if err != nil || len(peeked) != 3 {
+		t.Fatalf("expected 3 indexes up to the bound but got %v, %v", peeked, err)
+	}
+}
+
+func TestNextFastPathFollowsChanges(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		change func(*SegmentedIndex)
+		want   SegmentedIndexResult
+	}{
+		{name: "bound", change: func(s *SegmentedIndex) { s.SetMax(2) }, want: SegmentedIndexResult{Scaled: 2, Unscaled: 2, Done: true}},
+		{name: "frozen", change: func(s *SegmentedIndex) { s.Freeze() }, want: SegmentedIndexResult{Scaled: 2, Unscaled: 2, Done: true}},
+		{
+			name: "more offsets", change: func(s *SegmentedIndex) { _, _ = s.Reconfigure(0, 3, []int64{1, 2}) },
+			want: SegmentedIndexResult{Scaled: 3, Unscaled: 4, Step: 2},
+		},
+		{
+			name: "loaded state", change: func(s *SegmentedIndex) { _ = s.LoadState(`{"lcd":3,"offsets":[1,2],"scaled":2,"unscaled":2}`) },
+			want: SegmentedIndexResult{Scaled: 3, Unscaled: 4, Step: 2},
+		},
+		{name: "unbound", change: func(s *SegmentedIndex) { s.SetMax(2); s.SetMax(-1) }, want: SegmentedIndexResult{Scaled: 3, Unscaled: 3, Step: 1}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 1, []int64{1})
+			index.NextN(2)
+			tc.change(index)
+			if got := index.Next(); got != tc.want {
+				t.Fatalf("expected %+v but got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPrev(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		nexts   int64
+		want    SegmentedIndexResult
+		wantErr error
+	}{
+		{name: "at zero", nexts: 0, want: SegmentedIndexResult{}, wantErr: errPrevAtStart},
+		{name: "to zero", nexts: 1, want: SegmentedIndexResult{Step: 2}},
+		{name: "within a cycle", nexts: 2, want: SegmentedIndexResult{Scaled: 1, Unscaled: 2, Step: 1}},
+		{name: "last of a cycle", nexts: 3, want: SegmentedIndexResult{Scaled: 2, Unscaled: 3, Step: 2}},
+		{name: "over a cycle", nexts: 4, want: SegmentedIndexResult{Scaled: 3, Unscaled: 5, Step: 1}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(1, 3, []int64{1, 2})
+			index.NextN(tc.nexts)
+			got, err := index.Prev()
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v but got %v", tc.wantErr, err)
+			}
+			if got != tc.want || index.Current() != (SegmentedIndexResult{Scaled: tc.want.Scaled, Unscaled: tc.want.Unscaled}) {
+				t.Fatalf("expected %+v but got %+v and the index is at %+v", tc.want, got, index.Current())
+			}
+		})
+	}
+}
+
+func TestPrevAtZeroThrowsInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	runJS(t, rt, `var index = segment.custom(0, 3, [1, 2])`)
+	if _, err := rt.RunString(`index.prev()`); err == nil {
+		t.Fatal("expected prev at zero to throw")
+	}
+	if got := runJS(t, rt, `index.current().unscaled`).ToInteger(); got != 0 {
+		t.Fatalf("expected the index to stay at 0 but it's at %d", got)
+	}
+}
+
+func TestGoTo(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name  string
+		value int64
+		want  SegmentedIndexResult
+	}{
+		{name: "minus one", value: -1, want: SegmentedIndexResult{}},
+		{name: "most negative", value: math.MinInt64, want: SegmentedIndexResult{}},
+		{name: "zero", value: 0, want: SegmentedIndexResult{}},
+		{name: "before the first owned", value: 1, want: SegmentedIndexResult{}},
+		{name: "just below lcd", value: 2, want: SegmentedIndexResult{Scaled: 1, Unscaled: 2}},
+		{name: "lcd", value: 3, want: SegmentedIndexResult{Scaled: 2, Unscaled: 3}},
+		{name: "not owned", value: 4, want: SegmentedIndexResult{Scaled: 2, Unscaled: 3}},
+		{name: "last of the cycle", value: 5, want: SegmentedIndexResult{Scaled: 3, Unscaled: 5}},
+		{name: "next cycle", value: 6, want: SegmentedIndexResult{Scaled: 4, Unscaled: 6}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(1, 3, []int64{1, 2})
+			index.NextN(5)
+			if got := index.GoTo(tc.value); got != tc.want || index.Current() != tc.want {
+				t.Fatalf("expected %+v but got %+v and the index is at %+v", tc.want, got, index.Current())
+			}
+		})
+	}
+}
+
+func TestGoToScaledMatchesNext(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			stepped := index.Clone()
+			for scaled := int64(1); scaled <= 3*int64(len(index.offsets))+1; scaled++ {
+				want := stepped.Next()
+				want.Step = 0
+				if got := index.GoToScaled(scaled); got != want {
+					t.Fatalf("GoToScaled(%d) on %s of %q returned %+v but Next %+v", scaled, index, sequence, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestGoToScaledNegative(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.NextN(3)
+	if got := index.GoToScaled(-1); got != (SegmentedIndexResult{}) {
+		t.Fatalf("expected a negative scaled index to reset the index but got %+v", got)
+	}
+}
+
+func TestGoToScaledOverflow(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		start   int64
+		offsets []int64
+	}{
+		{name: "many offsets", offsets: []int64{1, 2}},
+		{name: "one offset", start: 1, offsets: []int64{3}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var lcd int64
+			for _, offset := range tc.offsets {
+				lcd += offset
+			}
+			last := NewSegmentedIndex(tc.start, lcd, tc.offsets).GoTo(math.MaxInt64)
+
+			index := NewSegmentedIndex(tc.start, lcd, tc.offsets)
+			got := index.GoToScaled(math.MaxInt64)
+			if want := (SegmentedIndexResult{Scaled: last.Scaled, Unscaled: last.Unscaled, Done: true}); got != want {
+				t.Fatalf("expected GoToScaled to stop at %+v but got %+v", want, got)
+			}
+			if got := index.GoToScaled(last.Scaled); got.Done || got.Unscaled != last.Unscaled {
+				t.Fatalf("expected GoToScaled(%d) to go to %+v but got %+v", last.Scaled, last, got)
+			}
+			if got := index.UnscaledAt(last.Scaled + 1); got != 0 {
+				t.Fatalf("expected UnscaledAt past the last scaled index to be 0 but got %d", got)
+			}
+			if got := index.UnscaledAt(last.Scaled); got != last.Unscaled {
+				t.Fatalf("expected UnscaledAt(%d) to be %d but got %d", last.Scaled, last.Unscaled, got)
+			}
+			if err := NewSegmentedIndex(tc.start, lcd, tc.offsets).SetInitialScaled(last.Scaled + 1); err == nil {
+				t.Fatal("expected SetInitialScaled past the last scaled index to return an error")
+			}
+		})
+	}
+}
+
+func TestReconfigureOverflow(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 1, []int64{1})
+	want := index.GoToScaled(math.MaxInt64 / 2)
+	if _, err := index.Reconfigure(0, 3, []int64{3}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := index.Current(); got != want {
+		t.Fatalf("expected the index to stay at %+v but it's at %+v", want, got)
+	}
+}
+
+func TestNewSegmentedIndexChecked(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		lcd     int64
+		offsets []int64
+		wantErr string
+	}{
+		{name: "valid", lcd: 3, offsets: []int64{1, 2}},
+		{name: "sum below lcd", lcd: 4, offsets: []int64{1, 2}, wantErr: "sum up to 3 instead of the lcd 4"},
+		{name: "sum above lcd", lcd: 2, offsets: []int64{1, 2}, wantErr: "sum up to 3 instead of the lcd 2"},
+		{name: "zero lcd", lcd: 0, offsets: []int64{1}, wantErr: "lcd must be positive"},
+		{name: "no offsets", lcd: 3, offsets: []int64{}, wantErr: "at least one offset"},
+		{name: "zero offset", lcd: 3, offsets: []int64{1, 0, 2}, wantErr: "offset 1 is 0"},
+		{name: "negative offset", lcd: 3, offsets: []int64{4, -1}, wantErr: "offset 1 is -1"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index, err := NewSegmentedIndexChecked(0, tc.lcd, tc.offsets)
+			if tc.wantErr == "" {
+				if err != nil || index == nil {
+					t.Fatalf("expected an index but got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected an error containing %q but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestCustomThrowsForInvalidParameters(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	if _, err := rt.RunString(`segment.custom(0, 4, [1, 2])`); err == nil || !strings.Contains(err.Error(), "lcd 4") {
+		t.Fatalf("expected custom to throw the error of the checked constructor but got %v", err)
+	}
+	if _, err := rt.RunString(`segment.custom(0, 3, [-1, 4])`); err == nil || !strings.Contains(err.Error(), "offset 0 is -1") {
+		t.Fatalf("expected custom to throw for a negative offset but got %v", err)
+	}
+}
+
+func TestConstructorsWithoutState(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name      string
+		construct func(m *Module, ctx context.Context) error
+	}{
+		{name: "SegmentedIndex", construct: func(m *Module, ctx context.Context) error {
+			_, err := m.XSegmentedIndex(ctx)
+			return err
+		}},
+		{name: "SharedSegmentedIndex", construct: func(m *Module, ctx context.Context) error {
+			_, err := m.XSharedSegmentedIndex(ctx, "shared")
+			return err
+		}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := common.WithRuntime(context.Background(), goja.New())
+			if err := tc.construct(New(), ctx); !errors.Is(err, errNoState) {
+				t.Fatalf("expected %v but got %v", errNoState, err)
+			}
+		})
+	}
+}
+
+func TestConstructorWithoutStateThrowsInJS(t *testing.T) {
+	t.Parallel()
+	rt := goja.New()
+	rt.SetFieldNameMapper(common.FieldNameMapper{})
+	ctx := common.WithRuntime(context.Background(), rt)
+	if err := rt.Set("segment", common.Bind(rt, New(), &ctx)); err != nil {
+		t.Fatal(err)
+	}
+	message := runJS(t, rt, `
+		try { new segment.SharedSegmentedIndex("shared"); "not thrown" } catch (e) { String(e) }
+	`).String()
+	if !strings.Contains(message, errNoState.Error()) {
+		t.Fatalf("expected a catchable %q but got %q", errNoState, message)
+	}
+}
+
+func TestTotalOwned(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		indexes := sequenceIndexes(t, sequence)
+		for size := int64(0); size <= 3*indexes[0].lcd+1; size++ {
+			var sum int64
+			for _, index := range indexes {
+				var counted int64
+				for stepped := index.Clone(); stepped.Next().Unscaled <= size; {
+					counted++
+				}
+				total := index.TotalOwned(size)
+				if total != counted {
+					t.Fatalf("TotalOwned(%d) on %s of %q is %d but Next goes through %d", size, index, sequence, total, counted)
+				}
+				sum += total
+			}
+			if sum != size {
+				t.Fatalf("TotalOwned(%d) of the segments of %q sum up to %d", size, sequence, sum)
+			}
+		}
+	}
+}
+
+func TestStripedOffsetsFromStrings(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		segment, sequence string
+		start, lcd        int64
+		offsets           []int64
+		wantErr           string
+	}{
+		{segment: "0:1", sequence: "0,1", start: 0, lcd: 1, offsets: []int64{1}},
+		{segment: "1/2:1", sequence: "0,1/2,1", start: 1, lcd: 2, offsets: []int64{2}},
+		{segment: "1/2:1", sequence: "", start: 1, lcd: 2, offsets: []int64{2}},
+		{segment: "1/3:2/3", sequence: "0,1/3,2/3,1", start: 1, lcd: 3, offsets: []int64{3}},
+		{segment: "3/10:6/10", sequence: "0,1/10,3/10,6/10,1", start: 1, lcd: 10, offsets: []int64{3, 3, 4}},
+		{segment: "0:2", sequence: "0,1", wantErr: "shouldn't be more than 1"},
+		{segment: "0:1/3", sequence: "0,1/2,1", wantErr: "couldn't find segment"},
+		{segment: "0:1", sequence: "0,a,1", wantErr: "a"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.segment+"@"+tc.sequence, func(t *testing.T) {
+			t.Parallel()
+			start, lcd, offsets, err := StripedOffsetsFromStrings(tc.segment, tc.sequence)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q but got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tc.start || lcd != tc.lcd || fmt.Sprint(offsets) != fmt.Sprint(tc.offsets) {
+				t.Fatalf("expected %d, %d, %v but got %d, %d, %v", tc.start, tc.lcd, tc.offsets, start, lcd, offsets)
+			}
+		})
+	}
+}
+
+func TestNextDoesNotOverflow(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name       string
+		start, lcd int64
+		offsets    []int64
+	}{
+		{name: "one offset", start: 0, lcd: 1, offsets: []int64{1}},
+		{name: "one big offset", start: 2, lcd: 5, offsets: []int64{5}},
+		{name: "many offsets", start: 0, lcd: 3, offsets: []int64{1, 2}},
+		{name: "many big offsets", start: 1, lcd: 10, offsets: []int64{3, 3, 4}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(tc.start, tc.lcd, tc.offsets)
+			previous := index.GoTo(math.MaxInt64 - 2*tc.lcd)
+			if previous.Unscaled <= 0 || previous.Scaled <= 0 {
+				t.Fatalf("expected GoTo to go close to the maximum but got %+v", previous)
+			}
+			for i := 0; ; i++ {
+				result := index.Next()
+				if result.Done {
+					if current := index.Current(); current != (SegmentedIndexResult{Scaled: previous.Scaled, Unscaled: previous.Unscaled}) {
+						t.Fatalf("expected the index to stay at %+v but it's at %+v", previous, current)
+					}
+					if step := index.step(result.Scaled); step <= math.MaxInt64-result.Unscaled {
+						t.Fatalf("expected Next to be done only on overflow but the step %d from %+v doesn't", step, result)
+					}
+					return
+				}
+				if result.Unscaled <= previous.Unscaled || i > int(2*tc.lcd) {
+					t.Fatalf("expected Next to move forward to the maximum but went from %+v to %+v", previous, result)
+				}
+				previous = result
+			}
+		})
+	}
+}
+
+// ownedUpTo returns the unscaled indexes Next goes through on a clone of index up to max.
+func ownedUpTo(index *SegmentedIndex, max int64) []int64 {
+	var owned []int64
+	for stepped := index.Clone(); ; {
+		result := stepped.Next()
+		if result.Done || result.Unscaled > max {
+			return owned
+		}
+		owned = append(owned, result.Unscaled)
+	}
+}
+
+func TestGoToCeilBracketsWithGoTo(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			owned := ownedUpTo(index, 4*index.lcd)
+			for value := int64(-1); value <= 3*index.lcd+1; value++ {
+				// the first owned index at or above value is the ceil and the one before it the floor
+				i := sort.Search(len(owned), func(i int) bool { return owned[i] >= value })
+				wantCeil, wantFloor := owned[i], int64(0)
+				if owned[i] == value {
+					wantFloor = value
+				} else if i > 0 {
+					wantFloor = owned[i-1]
+				}
+				if floor := index.GoTo(value); floor.Unscaled != wantFloor {
+					t.Fatalf("GoTo(%d) on %s of %q went to %+v instead of %d", value, index, sequence, floor, wantFloor)
+				}
+				if ceil := index.GoToCeil(value); ceil.Unscaled != wantCeil || ceil.Scaled != int64(i+1) {
+					t.Fatalf("GoToCeil(%d) on %s of %q went to %+v instead of %d", value, index, sequence, ceil, wantCeil)
+				}
+			}
+		}
+	}
+}
+
+func TestGoToCeilAtTheBound(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.SetMax(4)
+	if got, want := index.GoToCeil(6), (SegmentedIndexResult{Scaled: 4, Unscaled: 5, Done: true}); got != want {
+		t.Fatalf("expected GoToCeil to stay where GoTo went as Next is past the bound, %+v, but got %+v", want, got)
+	}
+}
+
+func TestGoToBatchMatchesGoTo(t *testing.T) {
+	t.Parallel()
+	r := rand.New(rand.NewSource(2))
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			values := make([]int64, 20)
+			for i := range values {
+				values[i] = r.Int63n(10*index.lcd) - 1
+			}
+			sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+			results := index.GoToBatch(values)
+			for i, value := range values {
+				if want := index.Clone().GoTo(value); results[i] != want {
+					t.Fatalf("GoToBatch on %s of %q returned %+v for %d but GoTo %+v", index, sequence, results[i], value, want)
+				}
+			}
+			if current := index.Current(); current != results[len(results)-1] {
+				t.Fatalf("expected GoToBatch to leave %s of %q at %+v but it's at %+v", index, sequence, results[len(results)-1], current)
+			}
+		}
+	}
+}
+
+func TestGoToBatchEmpty(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.NextN(2)
+	if results := index.GoToBatch(nil); len(results) != 0 {
+		t.Fatalf("expected no results but got %+v", results)
+	}
+	if current := index.Current(); current != (SegmentedIndexResult{Scaled: 2, Unscaled: 2}) {
+		t.Fatalf("expected the index not to move but it's at %+v", current)
+	}
+}
+
+func TestNextWithoutOffsets(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name string
+		lcd  int64
+	}{
+		{name: "zero lcd", lcd: 0},
+		{name: "positive lcd", lcd: 3},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, tc.lcd, []int64{})
+			for i := 0; i < 3; i++ {
+				if got := index.Next(); got != (SegmentedIndexResult{Done: true}) {
+					t.Fatalf("expected Next to be done right away but got %+v", got)
+				}
+			}
+			if got, _ := index.NextN(3); len(got) != 0 {
+				t.Fatalf("expected NextN to return nothing but got %+v", got)
+			}
+			if got := index.Current(); got != (SegmentedIndexResult{}) {
+				t.Fatalf("expected the index to stay at 0 but it's at %+v", got)
+			}
+		})
+	}
+}
+
+func TestConstructorsWithCancelledContext(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name      string
+		construct func(m *Module, ctx context.Context) error
+	}{
+		{name: "SegmentedIndex", construct: func(m *Module, ctx context.Context) error {
+			_, err := m.XSegmentedIndex(ctx)
+			return err
+		}},
+		{name: "SharedSegmentedIndex", construct: func(m *Module, ctx context.Context) error {
+			_, err := m.XSharedSegmentedIndex(ctx, "shared")
+			return err
+		}},
+		{name: "custom", construct: func(m *Module, ctx context.Context) error {
+			_, err := m.Custom(ctx, 0, 3, []int64{1, 2})
+			return err
+		}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithCancel(common.WithRuntime(context.Background(), goja.New()))
+			ctx = lib.WithState(ctx, testState(t, "", ""))
+			cancel()
+			if err := tc.construct(New(), ctx); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected %v but got %v", context.Canceled, err)
+			}
+		})
+	}
+}
+
+func TestGoToPrevOwned(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			owned := ownedUpTo(index, 4*index.lcd)
+			for _, value := range []int64{math.MinInt64, -1, 0, 1, 2, index.lcd, index.lcd + 1, 2 * index.lcd, 3*index.lcd + 1} {
+				var want SegmentedIndexResult
+				for i, unscaled := range owned {
+					if unscaled < value {
+						want = SegmentedIndexResult{Scaled: int64(i + 1), Unscaled: unscaled}
+					}
+				}
+				if got := index.GoToPrevOwned(value); got != want {
+					t.Fatalf("GoToPrevOwned(%d) on %s of %q went to %+v instead of %+v", value, index, sequence, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCompareAndNextMismatch(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.Next()
+	got := index.CompareAndNext(0)
+	if got.OK || got.Result != (SegmentedIndexResult{Scaled: 1, Unscaled: 1}) || index.Current() != got.Result {
+		t.Fatalf("expected a mismatch not to move the index but got %+v", got)
+	}
+}
+
+func TestCompareAndNextRacing(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	const vus, claims = 16, 200
+	claimed := make([]int32, vus*claims+1) // by scaled index
+	var wg sync.WaitGroup
+	for i := 0; i < vus; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for won := 0; won < claims; {
+				if result := index.CompareAndNext(index.GetScaled()); result.OK {
+					atomic.AddInt32(&claimed[result.Result.Scaled], 1)
+					won++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for scaled := 1; scaled < len(claimed); scaled++ {
+		if claimed[scaled] != 1 {
+			t.Fatalf("expected scaled index %d to be claimed once but it was %d times", scaled, claimed[scaled])
+		}
+	}
+	if current := index.GetScaled(); current != vus*claims {
+		t.Fatalf("expected the index to be at %d but it's at %d", vus*claims, current)
+	}
+}
+
+func TestGoToWithZeroLCD(t *testing.T) {
+	t.Parallel()
+	for _, value := range []int64{-1, 0, 1, 100, math.MaxInt64} {
+		index := NewSegmentedIndex(0, 0, []int64{1})
+		if got := index.GoTo(value); got != (SegmentedIndexResult{}) {
+			t.Fatalf("expected GoTo(%d) with a zero lcd to reset the index but got %+v", value, got)
+		}
+	}
+}
+
+func TestOwnedChanMatchesOwnedIndices(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			want, err := index.OwnedIndices(3*index.lcd + 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []int64
+			for unscaled := range index.OwnedChan(context.Background(), 3*index.lcd+1) {
+				got = append(got, unscaled)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Fatalf("OwnedChan on %s of %q sent %v but OwnedIndices returned %v", index, sequence, got, want)
+			}
+		}
+	}
+}
+
+func TestOwnedChanClosedOnCancel(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := NewSegmentedIndex(0, 3, []int64{1, 2}).OwnedChan(ctx, math.MaxInt64)
+	if got := <-ch; got != 1 {
+		t.Fatalf("expected the first owned index but got %d", got)
+	}
+	cancel()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected the channel to be closed once the context was cancelled")
+		}
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		mutate func(*SegmentedIndex) (SegmentedIndexResult, error)
+	}{
+		{name: "next", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.Next(), nil }},
+		{name: "prev", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.Prev() }},
+		{name: "advance", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.Advance(2) }},
+		{name: "rewind", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.Rewind(1) }},
+		{name: "reset", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.Reset(), nil }},
+		{name: "goTo", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.GoTo(10), nil }},
+		{name: "goToScaled", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.GoToScaled(10), nil }},
+		{name: "goToCeil", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.GoToCeil(10), nil }},
+		{name: "goToFraction", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.GoToFraction(10, 1) }},
+		{name: "remapUnscaled", mutate: func(s *SegmentedIndex) (SegmentedIndexResult, error) { return s.RemapUnscaled(1) }},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 3, []int64{1, 2})
+			index.NextN(2)
+			want := index.Current()
+			index.Freeze()
+			result, err := tc.mutate(index)
+			if !errors.Is(err, errFrozen) && !result.Done {
+				t.Fatalf("expected an error or Done but got %+v, %v", result, err)
+			}
+			if result.Scaled != want.Scaled || result.Unscaled != want.Unscaled || index.Current() != want {
+				t.Fatalf("expected the index to stay at %+v but got %+v and it's at %+v", want, result, index.Current())
+			}
+			index.Unfreeze()
+			if _, err = tc.mutate(index); err != nil {
+				t.Fatalf("expected no error once unfrozen but got %v", err)
+			}
+			if index.Current() == want {
+				t.Fatalf("expected the index to move once unfrozen but it's still at %+v", want)
+			}
+		})
+	}
+}
+
+func TestFrozenThrowsInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	runJS(t, rt, `var index = segment.custom(0, 3, [1, 2]); index.next(); index.freeze()`)
+	if _, err := rt.RunString(`index.prev()`); err == nil || !strings.Contains(err.Error(), errFrozen.Error()) {
+		t.Fatalf("expected prev on a frozen index to throw but got %v", err)
+	}
+	if got := runJS(t, rt, `index.next().done + " " + index.current().unscaled`).String(); got != "true 1" {
+		t.Fatalf("expected next on a frozen index to be done but got %q", got)
+	}
+}
+
+func TestFirstAndLastOwned(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		indexes := sequenceIndexes(t, sequence)
+		for max := int64(0); max <= 3*indexes[0].lcd+1; max++ {
+			var first, last int64 = math.MaxInt64, 0
+			for _, index := range indexes {
+				owned := ownedUpTo(index, max)
+				wantLast := int64(0)
+				if len(owned) > 0 {
+					wantLast = owned[len(owned)-1]
+				}
+				if got := index.LastOwned(max); got != wantLast {
+					t.Fatalf("LastOwned(%d) on %s of %q is %d instead of %d", max, index, sequence, got, wantLast)
+				}
+				if got, want := index.FirstOwned(), index.Clone().Next().Unscaled; got != want {
+					t.Fatalf("FirstOwned on %s of %q is %d instead of %d", index, sequence, got, want)
+				}
+				if index.FirstOwned() < first {
+					first = index.FirstOwned()
+				}
+				if wantLast > last {
+					last = wantLast
+				}
+			}
+			// between them the segments are responsible for everything from 1 to max
+			if first != 1 || last != max {
+				t.Fatalf("the segments of %q bracket %d to %d instead of 1 to %d", sequence, first, last, max)
+			}
+		}
+	}
+}
+
+func TestFirstOwnedWithoutOffsets(t *testing.T) {
+	t.Parallel()
+	if got := NewSegmentedIndex(0, 3, []int64{}).FirstOwned(); got != 0 {
+		t.Fatalf("expected 0 for an index owning nothing but got %d", got)
+	}
+}
+
+func TestRewindToCycleStart(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		nexts    int64
+		want     SegmentedIndexResult
+		wantNext int64 // the unscaled index Next goes to after rewinding
+	}{
+		{name: "at the start", nexts: 0, want: SegmentedIndexResult{}, wantNext: 2},
+		{name: "first of the first cycle", nexts: 1, want: SegmentedIndexResult{}, wantNext: 2},
+		{name: "mid first cycle", nexts: 2, want: SegmentedIndexResult{}, wantNext: 2},
+		{name: "at a boundary", nexts: 3, want: SegmentedIndexResult{Scaled: 3, Unscaled: 8}, wantNext: 12},
+		{name: "mid second cycle", nexts: 4, want: SegmentedIndexResult{Scaled: 3, Unscaled: 8}, wantNext: 12},
+		{name: "last of the second cycle", nexts: 5, want: SegmentedIndexResult{Scaled: 3, Unscaled: 8}, wantNext: 12},
+		{name: "mid third cycle", nexts: 7, want: SegmentedIndexResult{Scaled: 6, Unscaled: 18}, wantNext: 22},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(1, 10, []int64{3, 3, 4})
+			index.NextN(tc.nexts)
+			if got := index.RewindToCycleStart(); got != tc.want || index.Current() != tc.want {
+				t.Fatalf("expected %+v but got %+v and the index is at %+v", tc.want, got, index.Current())
+			}
+			if got := index.Next(); got.Unscaled != tc.wantNext {
+				t.Fatalf("expected Next to go through the cycle again from %d but got %+v", tc.wantNext, got)
+			}
+		})
+	}
+}
+
+func TestConstructorsShareParameters(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		segment, sequence string
+	}{
+		{segment: "", sequence: ""},
+		{segment: "1/2:1", sequence: ""},
+		{segment: "1/3:2/3", sequence: "0,1/3,2/3,1"},
+		{segment: "3/10:6/10", sequence: "0,1/10,3/10,6/10,1"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.segment+"@"+tc.sequence, func(t *testing.T) {
+			t.Parallel()
+			state := testState(t, tc.segment, tc.sequence)
+			want, err := buildStripedIndex(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := lib.WithState(common.WithRuntime(context.Background(), goja.New()), state)
+			m := New()
+			constructors := map[string]func() (*goja.Object, error){
+				"SegmentedIndex":       func() (*goja.Object, error) { return m.XSegmentedIndex(ctx) },
+				"SharedSegmentedIndex": func() (*goja.Object, error) { return m.XSharedSegmentedIndex(ctx, "shared") },
+				"VUSegmentedIndex":     func() (*goja.Object, error) { return m.XVUSegmentedIndex(ctx) },
+			}
+			for name, construct := range constructors {
+				obj, err := construct()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := obj.Export().(*SegmentedIndex)
+				if got.start != want.start || got.lcd != want.lcd || fmt.Sprint(got.offsets) != fmt.Sprint(want.offsets) {
+					t.Fatalf("%s has the parameters of %s instead of %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemapUnscaled(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		nexts   int64
+		newBase int64
+		want    SegmentedIndexResult
+		wantErr bool
+	}{
+		{name: "not moved", nexts: 0, newBase: 5, want: SegmentedIndexResult{}},
+		{name: "shifted up", nexts: 4, newBase: 5, want: SegmentedIndexResult{Scaled: 4, Unscaled: 16}},
+		{name: "shifted down", nexts: 4, newBase: 0, want: SegmentedIndexResult{Scaled: 4, Unscaled: 11}},
+		{name: "same base", nexts: 4, newBase: 1, want: SegmentedIndexResult{Scaled: 4, Unscaled: 12}},
+		{name: "negative base", nexts: 4, newBase: -1, want: SegmentedIndexResult{Scaled: 4, Unscaled: 12}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(1, 10, []int64{3, 3, 4})
+			index.NextN(tc.nexts)
+			got, err := index.RemapUnscaled(tc.newBase)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected an error to be %v but got %v", tc.wantErr, err)
+			}
+			if got != tc.want || index.Current() != tc.want {
+				t.Fatalf("expected %+v but got %+v and the index is at %+v", tc.want, got, index.Current())
+			}
+			if err := index.CheckConsistency(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRemapUnscaledOverflow(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 1, []int64{1})
+	want := index.GoTo(math.MaxInt64 - 1)
+	if _, err := index.RemapUnscaled(2); err == nil || index.Current() != want {
+		t.Fatalf("expected an error and the index to stay at %+v but got %v and it's at %+v", want, err, index.Current())
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name             string
+		start, lcd       int64
+		offsets          []int64
+		scaled, unscaled int64
+		wantErr          string
+	}{
+		{name: "consistent", start: 1, lcd: 10, offsets: []int64{3, 3, 4}, scaled: 4, unscaled: 12},
+		{name: "at the start", start: 1, lcd: 10, offsets: []int64{3, 3, 4}},
+		{name: "drifted", start: 1, lcd: 10, offsets: []int64{3, 3, 4}, scaled: 4, unscaled: 13, wantErr: "should be 12"},
+		{name: "drifted with one offset", start: 0, lcd: 2, offsets: []int64{2}, scaled: 2, unscaled: 4, wantErr: "should be 3"},
+		{name: "underflowed", start: 1, lcd: 10, offsets: []int64{3, 3, 4}, unscaled: -2, wantErr: "should be 0"},
+		{name: "negative scaled", start: 1, lcd: 10, offsets: []int64{3, 3, 4}, scaled: -1, wantErr: "is negative"},
+		{name: "no offsets", lcd: 3, offsets: []int64{}, scaled: 1, wantErr: "no offsets"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(tc.start, tc.lcd, tc.offsets)
+			index.scaled, index.unscaled = tc.scaled, tc.unscaled // corrupt it as misuse would
+			err := index.CheckConsistency()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected an error containing %q but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGoToWithoutOffsets(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name string
+		goTo func(*SegmentedIndex) SegmentedIndexResult
+	}{
+		{name: "goTo -1", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoTo(-1) }},
+		{name: "goTo 0", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoTo(0) }},
+		{name: "goTo 5", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoTo(5) }},
+		{name: "goTo max", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoTo(math.MaxInt64) }},
+		{name: "goToScaled", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoToScaled(5) }},
+		{name: "goToPrevOwned", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.GoToPrevOwned(5) }},
+		{name: "rewindToCycleStart", goTo: func(s *SegmentedIndex) SegmentedIndexResult { return s.RewindToCycleStart() }},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 3, []int64{})
+			if got := tc.goTo(index); got.Scaled != 0 || got.Unscaled != 0 || index.Current() != (SegmentedIndexResult{}) {
+				t.Fatalf("expected an index owning nothing to stay at 0 but got %+v", got)
+			}
+		})
+	}
+}
+
+func TestGoToFraction(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		fraction float64
+		want     SegmentedIndexResult
+		wantErr  bool
+	}{
+		{name: "none", fraction: 0, want: SegmentedIndexResult{}},
+		{name: "half", fraction: 0.5, want: SegmentedIndexResult{Scaled: 15, Unscaled: 48}},
+		{name: "rounded down", fraction: 0.51, want: SegmentedIndexResult{Scaled: 15, Unscaled: 48}},
+		{name: "all", fraction: 1, want: SegmentedIndexResult{Scaled: 30, Unscaled: 98}},
+		{name: "negative", fraction: -0.1, want: SegmentedIndexResult{Scaled: 2, Unscaled: 5}, wantErr: true},
+		{name: "above one", fraction: 1.1, want: SegmentedIndexResult{Scaled: 2, Unscaled: 5}, wantErr: true},
+		{name: "NaN", fraction: math.NaN(), want: SegmentedIndexResult{Scaled: 2, Unscaled: 5}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(1, 10, []int64{3, 3, 4}) // owns 30 of the first 100
+			index.NextN(2)
+			got, err := index.GoToFraction(100, tc.fraction)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected an error to be %v but got %v", tc.wantErr, err)
+			}
+			if got != tc.want || index.Current() != tc.want {
+				t.Fatalf("expected %+v but got %+v and the index is at %+v", tc.want, got, index.Current())
+			}
+		})
+	}
+}
+
+func TestGoToFractionInJS(t *testing.T) {
+	t.Parallel()
+	rt, _ := newTestRuntime(t, "", "")
+	if got := runJS(t, rt, `segment.custom(1, 10, [3, 3, 4]).goToFraction(100, 0.5).unscaled`).ToInteger(); got != 48 {
+		t.Fatalf("expected 48 but got %d", got)
+	}
+	if _, err := rt.RunString(`segment.custom(1, 10, [3, 3, 4]).goToFraction(100, 2)`); err == nil {
+		t.Fatal("expected a fraction above 1 to throw")
+	}
+}
+
+// steppedAdvance is Advance as n calls to Next or Prev, which is what it used to be.
+func steppedAdvance(s *SegmentedIndex, n int64) (result SegmentedIndexResult, err error) {
+	result = s.Current()
+	for ; n > 0; n-- {
+		if result = s.Next(); result.Done {
+			break
+		}
+	}
+	for ; n < 0; n++ {
+		if result, err = s.Prev(); err != nil {
+			break
+		}
+	}
+	return result, err
+}
+
+func TestAdvanceMatchesStepping(t *testing.T) {
+	t.Parallel()
+	for _, sequence := range testSequences {
+		for _, index := range sequenceIndexes(t, sequence) {
+			for _, max := range []int64{-1, 0, 5, 2*index.lcd + 1} {
+				for from := int64(0); from < 8; from++ {
+					for n := int64(-10); n <= 10; n++ {
+						advanced, stepped := index.Clone(), index.Clone()
+						advanced.SetMax(max)
+						stepped.SetMax(max)
+						advanced.GoToScaled(from)
+						stepped.GoToScaled(from)
+						got, gotErr := advanced.Advance(n)
+						want, wantErr := steppedAdvance(stepped, n)
+						if got != want || (gotErr == nil) != (wantErr == nil) || advanced.Current() != stepped.Current() {
+							t.Fatalf("Advance(%d) from %d on %s of %q bounded at %d returned %+v, %v but stepping %+v, %v",
+								n, from, index, sequence, max, got, gotErr, want, wantErr)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestAdvanceFar(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		max     int64 // no bound if negative
+		n       int64
+		want    SegmentedIndexResult
+		wantErr error
+	}{
+		{name: "unbounded", max: -1, n: 1 << 40, want: SegmentedIndexResult{Scaled: 1<<40 + 1, Unscaled: 3<<39 + 1, Step: 2}},
+		{name: "bounded", max: 1000, n: 1 << 40, want: SegmentedIndexResult{Scaled: 667, Unscaled: 1000, Done: true}},
+		{name: "overflow", max: -1, n: math.MaxInt64, want: SegmentedIndexResult{Scaled: 6148914691236517205, Unscaled: math.MaxInt64, Done: true}},
+		{name: "rewind past the start", max: -1, n: -(1 << 40), want: SegmentedIndexResult{}, wantErr: errPrevAtStart},
+		{name: "rewind the most", max: -1, n: math.MinInt64, want: SegmentedIndexResult{}, wantErr: errPrevAtStart},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 3, []int64{1, 2})
+			index.SetMax(tc.max)
+			index.Next()
+			got, err := index.Advance(tc.n)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Fatalf("expected %+v, %v but got %+v, %v", tc.want, tc.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func TestRewindFar(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.NextN(5)
+	if got, err := index.Rewind(1 << 40); !errors.Is(err, errPrevAtStart) || got != (SegmentedIndexResult{}) || index.Current() != got {
+		t.Fatalf("expected to stop at the start with an error but got %+v, %v", got, err)
+	}
+	index.NextN(5)
+	if got, err := index.Rewind(math.MinInt64); err != nil || got.Scaled != 6148914691236517205 {
+		t.Fatalf("expected Rewind(math.MinInt64) to advance as far as possible but got %+v, %v", got, err)
+	}
+}
+
+func TestSetInitialScaledWithoutOffsets(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{})
+	if err := index.SetInitialScaled(5); err != nil {
+		t.Fatal(err)
+	}
+	if current := index.Current(); current != (SegmentedIndexResult{}) {
+		t.Fatalf("expected an index owning nothing to stay at 0 but it's at %+v", current)
+	}
+	if _, err := index.Prev(); !errors.Is(err, errPrevAtStart) {
+		t.Fatalf("expected Prev to be at the start but got %v", err)
+	}
+	index.CurrentCycle()
+	if err := index.CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkNext(b *testing.B) {
+	benchmarks := []struct {
+		name    string
+		lcd     int64
+		offsets []int64
+	}{
+		{name: "one offset", lcd: 3, offsets: []int64{3}},
+		{name: "many offsets", lcd: 10, offsets: []int64{1, 2, 3, 4}},
+	}
+	for _, bm := range benchmarks {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			index := NewSegmentedIndex(0, bm.lcd, bm.offsets)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index.Next()
+			}
+		})
+		b.Run(bm.name+" parallel", func(b *testing.B) {
+			index := NewSegmentedIndex(0, bm.lcd, bm.offsets)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					index.Next()
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStripedIndex(b *testing.B) {
+	state := testState(b, "3/10:6/10", "0,1/10,3/10,6/10,1")
+	b.Run("cached", func(b *testing.B) {
+		m := New()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			striped, err := m.striped.get(state)
+			if err != nil {
+				b.Fatal(err)
+			}
+			NewSegmentedIndex(striped.start, striped.lcd, striped.offsets)
+		}
+	})
+	b.Run("built", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := buildStripedIndex(state); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkNextInto(b *testing.B) {
+	b.Run("next", func(b *testing.B) {
+		index := NewSegmentedIndex(0, 10, []int64{1, 2, 3, 4})
+		b.ReportAllocs()
+		var result SegmentedIndexResult
+		for i := 0; i < b.N; i++ {
+			result = index.Next()
+		}
+		_ = result
+	})
+	b.Run("nextInto", func(b *testing.B) {
+		index := NewSegmentedIndex(0, 10, []int64{1, 2, 3, 4})
+		b.ReportAllocs()
+		var result SegmentedIndexResult
+		for i := 0; i < b.N; i++ {
+			index.NextInto(&result)
+		}
+	})
+	b.Run("js next", func(b *testing.B) {
+		rt, _ := newTestRuntime(b, "", "")
+		next, ok := goja.AssertFunction(runJS(b, rt, `
+			var index = segment.custom(0, 10, [1, 2, 3, 4]);
+			(function() { return index.next().unscaled; })
+		`))
+		if !ok {
+			b.Fatal("not a function")
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := next(goja.Undefined()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGoToContention(b *testing.B) {
+	offsets := make([]int64, 10000)
+	for i := range offsets {
+		offsets[i] = int64(i%7 + 1)
+	}
+	var lcd int64
+	for _, offset := range offsets {
+		lcd += offset
+	}
+	benchmarks := []struct {
+		name string
+		goTo func(*SegmentedIndex, int64)
+	}{
+		{name: "none"},
+		{name: "goTo", goTo: func(s *SegmentedIndex, value int64) { s.GoTo(value) }},
+		// GoToBatch finds the position with the index locked as GoTo used to
+		{name: "locked goTo", goTo: func(s *SegmentedIndex, value int64) { s.GoToBatch([]int64{value}) }},
+	}
+	for _, bm := range benchmarks {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			index := NewSegmentedIndex(0, lcd, offsets)
+			done := make(chan struct{})
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				if bm.goTo == nil {
+					return
+				}
+				for value := int64(0); ; value += lcd / 3 {
+					select {
+					case <-done:
+						return
+					default:
+						bm.goTo(index, value)
+					}
+				}
+			}()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					index.Next()
+				}
+			})
+			b.StopTimer()
+			close(done)
+			<-stopped
+		})
+	}
+}
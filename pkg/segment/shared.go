@@ -0,0 +1,273 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"go.k6.io/k6/lib"
+)
+
+// sharedShards is the number of shards the shared indexes are split between.
+const sharedShards = 32
+
+type sharedSegmentedIndexes struct {
+	data *shardedMap
+	max  int64 // the default bound of new indexes
+}
+
+// sharedOptions are the options a shared index can be created with.
+type sharedOptions struct {
+	// Skip is how many scaled indexes to skip as in SetInitialScaled.
+	Skip int64 `js:"skip"`
+}
+
+// get returns the shared index with the given name, creating it with opts if it doesn't exist.
+// If opts are given but the index already exists with different ones a warning is logged.
+func (s *sharedSegmentedIndexes) get(state *lib.State, name string, opts *sharedOptions) (*SegmentedIndex, error) {
+	index, _, err := s.getOrCreate(state, name, opts, nil)
+	return index, err
+}
+
+// getOrCreate does what get does but also returns whether the index was created by this call.
+// If it was, init is called with it, if not nil, before any other call can get it.
+func (s *sharedSegmentedIndexes) getOrCreate(
+	state *lib.State, name string, opts *sharedOptions, init func(*SegmentedIndex),
+) (*SegmentedIndex, bool, error) {
+	identity := tupleIdentity(state)
+	sh := s.data.shard(name)
+	sh.mu.RLock()
+	entry, ok := sh.data[name]
+	sh.mu.RUnlock()
+	if !ok {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		entry, ok = sh.data[name]
+		if !ok {
+			// cache those
+			array, err := buildStripedIndex(state)
+			if err != nil {
+				return nil, false, err
+			}
+			array = withDefaultMax(array, s.max)
+			if opts != nil {
+				if err = array.SetInitialScaled(opts.Skip); err != nil {
+					return nil, false, err
+				}
+			}
+			if init != nil {
+				init(array)
+			}
+			sh.data[name] = sharedEntry{index: array, identity: identity}
+			return array, true, nil
+		}
+	}
+
+	// otherwise VUs with different segments or sequences would silently share the same stripe
+	if entry.identity != identity {
+		return nil, false, fmt.Errorf("shared segmented index %q was created for execution segment and sequence %s, "+
+			"but is requested for %s", name, entry.identity, identity)
+	}
+
+	array := entry.index
+	if opts != nil && state.Logger != nil {
+		if skip := array.initialScaledValue(); skip != opts.Skip {
+			state.Logger.Warnf("shared segmented index %q was already created with skip %d, ignoring skip %d",
+				name, skip, opts.Skip)
+		}
+	}
+
+	return array, false, nil
+}
+
+// delete removes the shared index with name and returns it, or nil if there wasn't one.
+func (s *sharedSegmentedIndexes) delete(name string) *SegmentedIndex {
+	sh := s.data.shard(name)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	entry, ok := sh.data[name]
+	if !ok {
+		return nil
+	}
+	delete(sh.data, name)
+	return entry.index
+}
+
+func (s *sharedSegmentedIndexes) names() []string {
+	var names []string
+	s.data.each(func(name string, _ *SegmentedIndex) {
+		names = append(names, name)
+	})
+	sort.Strings(names)
+	if names == nil {
+		names = []string{}
+	}
+	return names
+}
+
+func (s *sharedSegmentedIndexes) snapshot() map[string]SegmentedIndexResult {
+	snapshot := make(map[string]SegmentedIndexResult)
+	s.data.each(func(name string, index *SegmentedIndex) {
+		snapshot[name] = index.Current()
+	})
+	return snapshot
+}
+
+func (s *sharedSegmentedIndexes) totalScaled() int64 {
+	var total int64
+	s.data.each(func(_ string, index *SegmentedIndex) {
+		total += index.GetScaled()
+	})
+	return total
+}
+
+func (s *sharedSegmentedIndexes) resetAll() {
+	s.data.each(func(_ string, index *SegmentedIndex) {
+		index.Reset()
+	})
+}
+
+// sharedCursors holds the cursors over each shared index by the index and the cursor name.
+// They are keyed by the index rather than its name, so that a new index with the name of a
+// deleted one doesn't get the cursors of the old one.
+type sharedCursors struct {
+	data map[*SegmentedIndex]map[string]*SegmentedIndex
+	mu   sync.Mutex
+	max  int64 // the default bound of new cursors
+}
+
+// get returns the cursor with cursorName over index, creating it with the parameters of index if
+// it doesn't exist.
+func (c *sharedCursors) get(index *SegmentedIndex, cursorName string) *SegmentedIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cursors, ok := c.data[index]
+	if !ok {
+		cursors = make(map[string]*SegmentedIndex)
+		c.data[index] = cursors
+	}
+	cursor, ok := cursors[cursorName]
+	if !ok {
+		index.mx.RLock()
+		cursor = withDefaultMax(NewSegmentedIndex(index.start, index.lcd, index.offsets), c.max)
+		index.mx.RUnlock()
+		cursors[cursorName] = cursor
+	}
+	return cursor
+}
+
+// delete removes all the cursors over index.
+func (c *sharedCursors) delete(index *SegmentedIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, index)
+}
+
+// sharedScopes holds the shared indexes of each scope, which is a scenario.
+type sharedScopes struct {
+	data   map[string]*sharedSegmentedIndexes
+	mu     sync.Mutex
+	shards int   // the number of shards of the shared indexes of each scope
+	max    int64 // the default bound of new indexes
+}
+
+// get returns the shared indexes of scope, creating them if needed.
+func (s *sharedScopes) get(scope string) *sharedSegmentedIndexes {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes, ok := s.data[scope]
+	if !ok {
+		indexes = &sharedSegmentedIndexes{data: newShardedMap(s.shards), max: s.max}
+		s.data[scope] = indexes
+	}
+	return indexes
+}
+
+// close removes the shared indexes of scope and returns how many there were.
+func (s *sharedScopes) close(scope string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	indexes, ok := s.data[scope]
+	if !ok {
+		return 0
+	}
+	delete(s.data, scope)
+	return indexes.data.len()
+}
+
+// shardedMap is a map of names to SegmentedIndexes split between shards by the hash of the name,
+// so that different names mostly don't contend for the same lock.
+type shardedMap struct {
+	shards []mapShard
+}
+
+type mapShard struct {
+	data map[string]sharedEntry
+	mu   sync.RWMutex
+}
+
+// sharedEntry is a shared index and the identity of the tuple it was created for.
+type sharedEntry struct {
+	index    *SegmentedIndex
+	identity string
+}
+
+func newShardedMap(count int) *shardedMap {
+	m := &shardedMap{shards: make([]mapShard, count)}
+	for i := range m.shards {
+		m.shards[i].data = make(map[string]sharedEntry)
+	}
+	return m
+}
+
+// shard returns the shard name belongs to.
+func (m *shardedMap) shard(name string) *mapShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	return &m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// each calls f for each name and index in the map, locking one shard at a time.
+func (m *shardedMap) each(f func(name string, index *SegmentedIndex)) {
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for name, entry := range sh.data {
+			f(name, entry.index)
+		}
+		sh.mu.RUnlock()
+	}
+}
+
+// len returns the number of entries in the map.
+func (m *shardedMap) len() int {
+	var n int
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		n += len(sh.data)
+		sh.mu.RUnlock()
+	}
+	return n
+}
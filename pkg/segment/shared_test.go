@@ -0,0 +1,224 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dop251/goja"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/lib"
+)
+
+func TestCursor(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		script string
+		want   int64
+	}{
+		{
+			name: "independent",
+			script: `segment.cursor("shared", "reads").next(); segment.cursor("shared", "reads").next();
+				segment.cursor("shared", "writes").next().unscaled`,
+			want: 1,
+		},
+		{
+			name:   "same cursor",
+			script: `segment.cursor("shared", "reads").next(); segment.cursor("shared", "reads").next().unscaled`,
+			want:   2,
+		},
+		{
+			name:   "not the index",
+			script: `segment.cursor("shared", "reads").next(); segment.shared("shared").next().unscaled`,
+			want:   1,
+		},
+		{
+			name: "deleted index",
+			script: `segment.cursor("shared", "reads").next(); segment.deleteShared("shared");
+				segment.cursor("shared", "reads").next().unscaled`,
+			want: 1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			rt, _ := newTestRuntime(t, "", "")
+			if got := runJS(t, rt, tc.script).ToInteger(); got != tc.want {
+				t.Fatalf("expected %d but got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDeleteSharedRemovesCursors(t *testing.T) {
+	t.Parallel()
+	rt, m := newTestRuntime(t, "", "")
+	runJS(t, rt, `segment.cursor("a", "reads"); segment.cursor("a", "writes"); segment.cursor("b", "reads")`)
+	if !m.DeleteShared("a") {
+		t.Fatal("expected a to be deleted")
+	}
+	if got := len(m.cursors.data); got != 1 {
+		t.Fatalf("expected the cursors over b only but there are cursors over %d indexes", got)
+	}
+	if m.DeleteShared("a") {
+		t.Fatal("expected a to be deleted already")
+	}
+}
+
+func TestSharedConflictingTuples(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name              string
+		segment, sequence string
+		wantErr           bool
+	}{
+		{name: "same tuple", segment: "0:1/2", sequence: "0,1/2,1"},
+		{name: "other segment", segment: "1/2:1", sequence: "0,1/2,1", wantErr: true},
+		{name: "other sequence", segment: "0:1/2", sequence: "0,1/4,1/2,1", wantErr: true},
+		{name: "no sequence", segment: "0:1/2", wantErr: true},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			shared := &sharedSegmentedIndexes{data: newShardedMap(sharedShards)}
+			first, err := shared.get(testState(t, "0:1/2", "0,1/2,1"), "shared", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := shared.get(testState(t, tc.segment, tc.sequence), "shared", nil)
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), `"shared" was created for execution segment`) {
+					t.Fatalf("expected the conflicting tuple error but got %v", err)
+				}
+				return
+			}
+			if err != nil || second != first {
+				t.Fatalf("expected the same index but got %v", err)
+			}
+		})
+	}
+}
+
+func TestInitSharedOnce(t *testing.T) {
+	t.Parallel()
+	m := New()
+	state := testState(t, "0:1/2", "0,1/2,1")
+	ctx := lib.WithState(context.Background(), state)
+	const vus = 32
+	var initialized int32
+	var wg sync.WaitGroup
+	for i := 0; i < vus; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			created, err := m.InitShared(ctx, "shared", int64(10+i))
+			if err != nil {
+				t.Error(err)
+			}
+			if created {
+				atomic.AddInt32(&initialized, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if initialized != 1 {
+		t.Fatalf("expected exactly one call to initialize the index but %d did", initialized)
+	}
+	index, err := m.shared.get(state, "shared", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// it is where GoTo of the value of whichever call won went, and the values are 10 to 10+vus-1
+	if current := index.Current(); current.Unscaled < 9 || current.Unscaled > 10+vus {
+		t.Fatalf("expected the index to be where one of the calls moved it but it's at %+v", current)
+	}
+	if created, err := m.InitShared(ctx, "shared", 0); created || err != nil {
+		t.Fatalf("expected an existing index not to be initialized again but got %v, %v", created, err)
+	}
+}
+
+func TestSharedSegmentedIndexExCreatedOnce(t *testing.T) {
+	t.Parallel()
+	m := New()
+	const vus = 32
+	var created int32
+	var wg sync.WaitGroup
+	for i := 0; i < vus; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each VU has its own runtime
+			ctx := common.WithRuntime(context.Background(), goja.New())
+			ctx = lib.WithState(ctx, testState(t, "", ""))
+			result, err := m.XSharedSegmentedIndexEx(ctx, "shared")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Created {
+				atomic.AddInt32(&created, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 {
+		t.Fatalf("expected exactly one VU to see created but %d did", created)
+	}
+}
+
+func BenchmarkSharedGet(b *testing.B) {
+	state := testState(b, "", "")
+	names := make([]string, 256)
+	for i := range names {
+		names[i] = "index" + strconv.Itoa(i)
+	}
+	benchmarks := []struct {
+		name   string
+		shards int
+	}{
+		{name: "one shard", shards: 1},
+		{name: "sharded", shards: sharedShards},
+	}
+	for _, bm := range benchmarks {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			shared := &sharedSegmentedIndexes{data: newShardedMap(bm.shards)}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				var i int
+				for pb.Next() {
+					if _, err := shared.get(state, names[i%len(names)], nil); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
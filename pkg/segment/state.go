@@ -0,0 +1,107 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// segmentedIndexState is the JSON representation of a SegmentedIndex.
+type segmentedIndexState struct {
+	Start    int64   `json:"start"`
+	LCD      int64   `json:"lcd"`
+	Offsets  []int64 `json:"offsets"`
+	Scaled   int64   `json:"scaled"`
+	Unscaled int64   `json:"unscaled"`
+}
+
+// MarshalJSON implements json.Marshaler by serializing both the parameters
+// of the index and its current position.
+func (s *SegmentedIndex) MarshalJSON() ([]byte, error) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	scaled, unscaled := s.position()
+	return json.Marshal(segmentedIndexState{
+		Start:    s.start,
+		LCD:      s.lcd,
+		Offsets:  s.offsets,
+		Scaled:   scaled,
+		Unscaled: unscaled,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler and replaces both the parameters
+// and the position of the index with the ones in data.
+func (s *SegmentedIndex) UnmarshalJSON(data []byte) error {
+	var state segmentedIndexState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if err := checkParameters(state.LCD, state.Offsets); err != nil {
+		return fmt.Errorf("invalid segmented index state: %w", err)
+	}
+	if err := NewSegmentedIndex(state.Start, state.LCD, state.Offsets).checkPosition(state.Scaled, state.Unscaled); err != nil {
+		return fmt.Errorf("invalid segmented index state: %w", err)
+	}
+
+	s.mx.Lock()
+	defer s.unlockAndNotifyJump(s.scaled, s.unscaled)
+	if s.frozen {
+		return errFrozen
+	}
+	s.start, s.lcd, s.offsets = state.Start, state.LCD, state.Offsets
+	s.prefixSums = prefixSums(state.Offsets)
+	s.updateFastNext()
+	s.scaled, s.unscaled = state.Scaled, state.Unscaled
+	s.signal()
+	return nil
+}
+
+// SaveState returns the state of the index as a JSON string that can later
+// be given to LoadState.
+func (s *SegmentedIndex) SaveState() (string, error) {
+	b, err := s.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// LoadState restores the index to a state previously returned by SaveState.
+func (s *SegmentedIndex) LoadState(state string) error {
+	return s.UnmarshalJSON([]byte(state))
+}
+
+// DumpState returns the state of every shared index, as SaveState would return it, in a JSON
+// object by their names. As with Snapshot each state is consistent, but they are not all taken
+// at the same time.
+func (m *Module) DumpState() (string, error) {
+	indexes := make(map[string]*SegmentedIndex)
+	m.shared.data.each(func(name string, index *SegmentedIndex) {
+		indexes[name] = index
+	})
+	b, err := json.Marshal(indexes)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,103 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadState(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		state   string
+		want    SegmentedIndexResult
+		wantErr string
+	}{
+		{name: "start", state: `{"start":1,"lcd":10,"offsets":[3,3,4],"scaled":0,"unscaled":0}`},
+		{
+			name: "moved", state: `{"start":1,"lcd":10,"offsets":[3,3,4],"scaled":4,"unscaled":12}`,
+			want: SegmentedIndexResult{Scaled: 4, Unscaled: 12},
+		},
+		{name: "no offsets", state: `{"start":0,"lcd":3,"offsets":[],"scaled":0,"unscaled":0}`, wantErr: "at least one offset"},
+		{name: "negative scaled", state: `{"start":0,"lcd":3,"offsets":[1,2],"scaled":-5,"unscaled":99}`, wantErr: "is negative"},
+		{name: "wrong unscaled", state: `{"start":0,"lcd":3,"offsets":[1,2],"scaled":2,"unscaled":3}`, wantErr: "should be 2"},
+		{
+			name: "past the last", state: `{"start":0,"lcd":3,"offsets":[1,2],"scaled":9223372036854775807,"unscaled":1}`,
+			wantErr: "past the last",
+		},
+		{name: "not JSON", state: `{`, wantErr: "unexpected end"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			index := NewSegmentedIndex(0, 1, []int64{1})
+			index.NextN(2)
+			err := index.LoadState(tc.state)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected an error containing %q but got %v", tc.wantErr, err)
+				}
+				if current := index.Current(); current != (SegmentedIndexResult{Scaled: 2, Unscaled: 2}) {
+					t.Fatalf("expected the index not to change but it's at %+v", current)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current := index.Current(); current != tc.want {
+				t.Fatalf("expected the index to be at %+v but it's at %+v", tc.want, current)
+			}
+			if saved, err := index.SaveState(); err != nil || saved != tc.state {
+				t.Fatalf("expected to save %s again but got %s, %v", tc.state, saved, err)
+			}
+		})
+	}
+}
+
+func TestLoadStateWakesWaiters(t *testing.T) {
+	t.Parallel()
+	index := NewSegmentedIndex(0, 3, []int64{1, 2})
+	index.SetMax(4)
+	index.NextN(3)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		if err := index.LoadState(`{"start":0,"lcd":3,"offsets":[1,2],"scaled":0,"unscaled":0}`); err != nil {
+			t.Error(err)
+		}
+	}()
+	start := time.Now()
+	result, err := index.ClaimOrWait(context.Background(), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected loading the state to wake ClaimOrWait up but it waited for %s", elapsed)
+	}
+	if want := (SegmentedIndexResult{Scaled: 1, Unscaled: 1, Step: 1}); result.TimedOut || result.SegmentedIndexResult != want {
+		t.Fatalf("expected to claim %+v once the state was loaded but got %+v", want, result)
+	}
+}
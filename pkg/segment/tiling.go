@@ -0,0 +1,70 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"fmt"
+
+	"go.k6.io/k6/lib"
+)
+
+// ValidateSequenceTiling checks that the indexes of all the segments in sequence together go
+// through each unscaled index from 1 to datasetSize exactly once, returning an error describing
+// the first gap or overlap if they don't. datasetSize can't be more than maxOwnedIndices.
+func ValidateSequenceTiling(sequence string, datasetSize int64) error {
+	if datasetSize < 0 || datasetSize > maxOwnedIndices {
+		return fmt.Errorf("datasetSize must be between 0 and %d but is %d", maxOwnedIndices, datasetSize)
+	}
+	ess, err := lib.NewExecutionSegmentSequenceFromString(sequence)
+	if err != nil {
+		return err
+	}
+	if len(ess) == 0 {
+		return fmt.Errorf("sequence %q has no segments", sequence)
+	}
+
+	// owners[i] is the position in ess plus one of the segment owning unscaled index i
+	owners := make([]int, datasetSize+1)
+	for i, es := range ess {
+		tuple, err := lib.NewExecutionTuple(es, &ess)
+		if err != nil {
+			return err
+		}
+		start, offsets, lcd := tuple.GetStripedOffsets()
+		owned, err := NewSegmentedIndex(start, lcd, offsets).OwnedIndices(datasetSize)
+		if err != nil {
+			return err
+		}
+		for _, unscaled := range owned {
+			if owner := owners[unscaled]; owner != 0 {
+				return fmt.Errorf("unscaled index %d is owned by both segment %s and segment %s",
+					unscaled, ess[owner-1], es)
+			}
+			owners[unscaled] = i + 1
+		}
+	}
+	for unscaled := int64(1); unscaled <= datasetSize; unscaled++ {
+		if owners[unscaled] == 0 {
+			return fmt.Errorf("unscaled index %d isn't owned by any segment of %q", unscaled, sequence)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSequenceTiling(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		sequence    string
+		datasetSize int64
+		wantErr     string
+	}{
+		{sequence: "0,1", datasetSize: 10},
+		{sequence: "0,1/3,2/3,1", datasetSize: 0},
+		{sequence: "0,1/3,2/3,1", datasetSize: 100},
+		{sequence: "0,1/10,3/10,6/10,1", datasetSize: 1000},
+		{sequence: "0,1/7,2/5,1/2,1", datasetSize: 1001},
+		{sequence: "0,1/2,1", datasetSize: -1, wantErr: "datasetSize must be between"},
+		{sequence: "0,1/2,1", datasetSize: maxOwnedIndices + 1, wantErr: "datasetSize must be between"},
+		{sequence: "0,1/2,a", datasetSize: 10, wantErr: "a"},
+		{sequence: "0,1/2,1/3,1", datasetSize: 10, wantErr: "should be less than its end"},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.sequence, func(t *testing.T) {
+			t.Parallel()
+			err := ValidateSequenceTiling(tc.sequence, tc.datasetSize)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected %q to tile %d indexes but got %v", tc.sequence, tc.datasetSize, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected an error containing %q but got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
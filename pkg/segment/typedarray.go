@@ -0,0 +1,78 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/dop251/goja"
+)
+
+// typedArrayMax is the biggest unscaled index that can be written exactly into each of the kinds
+// of typed arrays NextIntoArray supports.
+var typedArrayMax = map[string]int64{
+	"Float64Array": 1 << 53,
+	"Int32Array":   math.MaxInt32,
+	"Uint32Array":  math.MaxUint32,
+}
+
+// NextIntoArray calls Next up to buf.length times under a single lock and writes each unscaled
+// index into buf, so that JS can get many unscaled indexes without a result object for each.
+// buf must be a Float64Array, an Int32Array or a Uint32Array. It returns how many unscaled
+// indexes were written, which is less than buf.length if Next returns Done first or the next
+// unscaled index can't be written exactly into buf, in which case the index isn't moved past it.
+// It's not called nextInto in JS as NextInto already is.
+func (s *SegmentedIndex) NextIntoArray(buf *goja.Object) (int64, error) {
+	if buf == nil {
+		return 0, errors.New("nextIntoArray needs a typed array")
+	}
+	kind := ""
+	if tag := buf.GetSymbol(goja.SymToStringTag); tag != nil {
+		kind = tag.String()
+	}
+	unscaledMax, ok := typedArrayMax[kind]
+	if !ok {
+		return 0, fmt.Errorf("nextIntoArray supports Float64Array, Int32Array and Uint32Array but got %q", kind)
+	}
+	length := buf.Get("length").ToInteger()
+
+	// the results are collected first so that buf isn't written to with the index locked
+	results := make([]SegmentedIndexResult, 0, length)
+	s.mx.Lock()
+	for int64(len(results)) < length {
+		result := s.nextUpTo(unscaledMax)
+		if result.Done {
+			break
+		}
+		results = append(results, result)
+	}
+	s.unlockAndNotify(results...)
+
+	for i, result := range results {
+		if err := buf.Set(strconv.Itoa(i), result.Unscaled); err != nil {
+			return int64(i), err
+		}
+	}
+	return int64(len(results)), nil
+}
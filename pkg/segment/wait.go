@@ -0,0 +1,80 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// WaitForUnscaled blocks until the unscaled index is at least target or ctx is done, in which
+// case its error is returned.
+func (s *SegmentedIndex) WaitForUnscaled(ctx context.Context, target int64) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	defer s.broadcastOnDone(ctx)()
+
+	for s.unscaled < target {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.advanced.Wait()
+	}
+	return nil
+}
+
+// broadcastOnDone creates s.advanced if needed and broadcasts on it once ctx is done, as the cond
+// can't wait on ctx so it needs to be woken up then. The returned function must be called once
+// done waiting. It must be called with s.mx held.
+func (s *SegmentedIndex) broadcastOnDone(ctx context.Context) func() {
+	if s.advanced == nil {
+		s.advanced = sync.NewCond(&s.mx)
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mx.Lock()
+			s.advanced.Broadcast()
+			s.mx.Unlock()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// signal wakes up all the calls to WaitForUnscaled and ClaimOrWait. It must be called with s.mx
+// held.
+func (s *SegmentedIndex) signal() {
+	if s.advanced != nil {
+		s.advanced.Broadcast()
+	}
+}
+
+// WaitForUnscaled blocks until the unscaled index of index is at least target or the iteration
+// is interrupted.
+func (m *Module) WaitForUnscaled(ctx context.Context, index *SegmentedIndex, target int64) error {
+	if index == nil {
+		return errors.New("no segmented index provided to waitForUnscaled")
+	}
+	return index.WaitForUnscaled(ctx, target)
+}
@@ -0,0 +1,100 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/dop251/goja"
+)
+
+// WeightedOffsets returns offsets that sum up to lcd and are proportional to weights. Each offset
+// is at least 1 so that it can be used by a SegmentedIndex, and the rest of lcd is split by the
+// weights with the largest remainder method, so lcd must be at least the number of weights.
+func WeightedOffsets(lcd int64, weights []float64) ([]int64, error) {
+	if len(weights) == 0 {
+		return nil, errors.New("there must be at least one weight")
+	}
+	if lcd < int64(len(weights)) {
+		return nil, fmt.Errorf("lcd %d is smaller than the %d weights", lcd, len(weights))
+	}
+	var max float64
+	for i, weight := range weights {
+		if !(weight > 0) || math.IsInf(weight, 1) { // also catches NaN
+			return nil, fmt.Errorf("weights must be positive but weight %d is %v", i, weight)
+		}
+		max = math.Max(max, weight)
+	}
+	// the weights are scaled by the biggest one so that their sum can't overflow
+	var sum float64
+	for _, weight := range weights {
+		sum += weight / max
+	}
+
+	rest := lcd - int64(len(weights))
+	offsets := make([]int64, len(weights))
+	remainders := make([]float64, len(weights))
+	left := rest
+	for i, weight := range weights {
+		share := float64(rest) * (weight / max) / sum
+		whole := rest
+		if share < float64(rest) { // float64(rest) can be rounded up past what int64 can hold
+			whole = int64(share)
+		}
+		offsets[i] = 1 + whole
+		remainders[i] = share - float64(whole)
+		left -= whole
+	}
+	// what is left because of the rounding down goes to the biggest remainders
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]] > remainders[order[b]] })
+	for i := int64(0); i < left; i++ {
+		offsets[order[i%int64(len(order))]]++
+	}
+	// rounding errors with a huge lcd could still make them not sum up to it
+	if err := checkParameters(lcd, offsets); err != nil {
+		return nil, err
+	}
+	return offsets, nil
+}
+
+// Weighted returns a new SegmentedIndex starting from 0 with lcd and the offsets WeightedOffsets
+// returns for weights.
+func (m *Module) Weighted(ctx context.Context, lcd int64, weights []float64) (_ *goja.Object, err error) {
+	defer m.handleError(ctx, &err)
+	offsets, err := WeightedOffsets(lcd, weights)
+	if err != nil {
+		return nil, err
+	}
+	index, err := NewSegmentedIndexChecked(0, lcd, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	return toJSIndex(ctx, withDefaultMax(index, m.opts.DefaultMax))
+}
@@ -0,0 +1,85 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2021 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package segment
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWeightedOffsets(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		lcd     int64
+		weights []float64
+	}{
+		{name: "equal", lcd: 9, weights: []float64{1, 1, 1}},
+		{name: "uneven", lcd: 10, weights: []float64{1, 2, 3}},
+		{name: "as many as lcd", lcd: 3, weights: []float64{1, 100, 1}},
+		{name: "tiny", lcd: 7, weights: []float64{1e-300, 2e-300}},
+		{name: "huge", lcd: 10, weights: []float64{1e308, 1e308}},
+		{name: "huge and tiny", lcd: 1000, weights: []float64{math.MaxFloat64, math.SmallestNonzeroFloat64}},
+		{name: "huge lcd", lcd: math.MaxInt64, weights: []float64{1, 3}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			offsets, err := WeightedOffsets(tc.lcd, tc.weights)
+			if err != nil {
+				// with a huge lcd the rounding can make them not sum up, but that must be reported
+				if tc.lcd != math.MaxInt64 {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err := checkParameters(tc.lcd, offsets); err != nil {
+				t.Fatalf("offsets %v for lcd %d: %s", offsets, tc.lcd, err)
+			}
+		})
+	}
+}
+
+func TestWeightedOffsetsErrors(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		lcd     int64
+		weights []float64
+	}{
+		{name: "no weights", lcd: 10},
+		{name: "lcd too small", lcd: 2, weights: []float64{1, 1, 1}},
+		{name: "zero", lcd: 10, weights: []float64{1, 0}},
+		{name: "negative", lcd: 10, weights: []float64{1, -1}},
+		{name: "NaN", lcd: 10, weights: []float64{math.NaN()}},
+		{name: "Inf", lcd: 10, weights: []float64{math.Inf(1)}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if offsets, err := WeightedOffsets(tc.lcd, tc.weights); err == nil {
+				t.Fatalf("expected an error but got offsets %v", offsets)
+			}
+		})
+	}
+}